@@ -0,0 +1,74 @@
+package rbtree
+
+import "sort"
+
+// Batch buffers Puts and Deletes and applies them to a tree by Commit.
+// A Batch is not safe for concurrent use, each writer should have its own.
+type Batch struct {
+	t    *rbTree
+	lock func() (unlock func()) // nil if the tree is not shared
+	ops  []batchOp
+}
+
+type batchOp struct {
+	key, value interface{}
+	del        bool
+}
+
+// NewBatch returns an empty Batch of t.
+func (t *rbTree) NewBatch() *Batch {
+	return &Batch{t: t}
+}
+
+// NewBatch returns an empty Batch of s, its Commit holds the lock of s once for all the operations.
+func (s *SyncTree) NewBatch() *Batch {
+	return &Batch{t: s.t, lock: func() func() {
+		s.mu.Lock()
+		return s.mu.Unlock
+	}}
+}
+
+// Put buffers a Put of the key-value pair.
+// O(1)
+func (b *Batch) Put(key interface{}, value interface{}) {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+}
+
+// Delete buffers a Delete of key.
+// O(1)
+func (b *Batch) Delete(key interface{}) {
+	b.ops = append(b.ops, batchOp{key: key, del: true})
+}
+
+// Len returns the count of buffered operations.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Commit applies the buffered operations, the tree ends up the same as if they were applied one by one,
+// then the Batch is empty and can be reused.
+// The operations are applied in ASC of key, the operations on a same key keep their order. Each operation still
+// walks from the root, the order only makes the successive walks go down mostly the same path, which is more
+// likely in the CPU cache. On a capped tree, they are applied in the buffered order, since which key-value is
+// evicted depends on it.
+// Since the Puts are applied in ASC of key, the versions of GetWithVersion and ChangedSince are increasing in
+// the key order after Commit, not in the buffered order as if the Puts were applied one by one.
+// O(MlogM + MlogN), M = Len()
+func (b *Batch) Commit() {
+	if b.t.maxEntries <= 0 {
+		sort.SliceStable(b.ops, func(i, j int) bool {
+			return b.t.cmp(b.ops[i].key, b.ops[j].key) < 0
+		})
+	}
+	if b.lock != nil {
+		defer b.lock()()
+	}
+	for _, op := range b.ops {
+		if op.del {
+			b.t.Delete(op.key)
+		} else {
+			b.t.Put(op.key, op.value)
+		}
+	}
+	b.ops = b.ops[:0]
+}
@@ -0,0 +1,90 @@
+package rbtree
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/shengmingzhu/datastructures/pair"
+)
+
+func TestBatchCommit(t *testing.T) {
+	a, b := New(intCmp), New(intCmp)
+	ba := b.NewBatch()
+	// Repeated operations on a same key must be applied in the buffered order.
+	for _, op := range []struct {
+		key, value int
+		del        bool
+	}{
+		{key: 1, value: 10}, {key: 1, del: true}, {key: 1, value: 11},
+		{key: 2, value: 20}, {key: 2, value: 21}, {key: 2, del: true},
+		{key: 3, del: true}, {key: 3, value: 30},
+		{key: 0, value: 1}, {key: 3, value: 31}, {key: 1, value: 12},
+	} {
+		if op.del {
+			a.Delete(op.key)
+			ba.Delete(op.key)
+		} else {
+			a.Put(op.key, op.value)
+			ba.Put(op.key, op.value)
+		}
+	}
+	ba.Commit()
+	want := []pair.Pair{{First: 0, Second: 1}, {First: 1, Second: 12}, {First: 3, Second: 31}}
+	if !reflect.DeepEqual(a.RangeAll(), want) || !reflect.DeepEqual(b.RangeAll(), want) {
+		t.Fatalf("got %v, one by one %v, want %v", b.RangeAll(), a.RangeAll(), want)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	s := NewSync(intCmp)
+	for round := 0; round < 5; round++ {
+		ba, bs := b.NewBatch(), s.NewBatch()
+		for i := 0; i < 500; i++ {
+			k, v := r.Intn(100), r.Int()
+			if r.Intn(3) == 0 {
+				a.Delete(k)
+				ba.Delete(k)
+				bs.Delete(k)
+			} else {
+				a.Put(k, v)
+				ba.Put(k, v)
+				bs.Put(k, v)
+			}
+		}
+		ba.Commit()
+		bs.Commit()
+		if ba.Len() != 0 || bs.Len() != 0 {
+			t.Fatalf("Len after Commit got %d and %d, want 0", ba.Len(), bs.Len())
+		}
+		if !reflect.DeepEqual(b.RangeAll(), a.RangeAll()) || !reflect.DeepEqual(s.RangeAll(), a.RangeAll()) {
+			t.Fatalf("round %d: Commit differs from the operations one by one", round)
+		}
+		mustValid(t, b)
+	}
+
+	// The versions follow the key order, not the buffered order.
+	v := New(intCmp)
+	bv := v.NewBatch()
+	for _, k := range []int{3, 1, 2} {
+		bv.Put(k, nil)
+	}
+	bv.Commit()
+	_, v1, _ := v.GetWithVersion(1)
+	_, v2, _ := v.GetWithVersion(2)
+	_, v3, _ := v.GetWithVersion(3)
+	if !(v1 < v2 && v2 < v3) {
+		t.Fatalf("versions after Commit got %d %d %d, want increasing", v1, v2, v3)
+	}
+
+	// On a capped tree, the evictions depend on the buffered order.
+	c1, c2 := NewCapped(intCmp, 3, EvictMin, nil), NewCapped(intCmp, 3, EvictMin, nil)
+	bc := c2.NewBatch()
+	for _, k := range []int{5, 1, 9, 2, 7} {
+		c1.Put(k, k)
+		bc.Put(k, k)
+	}
+	bc.Commit()
+	if !reflect.DeepEqual(c2.RangeAll(), c1.RangeAll()) {
+		t.Fatalf("capped got %v, want %v", c2.RangeAll(), c1.RangeAll())
+	}
+}
@@ -0,0 +1,63 @@
+package rbtree
+
+import (
+	"container/list"
+	"sync"
+)
+
+// NewCachedCmp wraps f with a cache of the recent cacheSize results, for an expensive f such as locale-aware collation.
+// f must be pure, the same keys always compare to the same result, otherwise the tree would be corrupted.
+// Keys are cached by ==, so they must be comparable, pointer keys are cached by identity.
+// The returned CmpFunc is safe for concurrent use, e.g. readers of a SyncTree.
+func NewCachedCmp(f CmpFunc, cacheSize int) CmpFunc {
+	if cacheSize <= 0 {
+		return f
+	}
+	c := &cmpCache{f: f, size: cacheSize, items: make(map[cmpArgs]*list.Element, cacheSize), lru: list.New()}
+	return c.cmp
+}
+
+type cmpArgs struct {
+	key1, key2 interface{}
+}
+
+type cmpResult struct {
+	args cmpArgs
+	res  int
+}
+
+// cmpCache is a LRU cache of the results of f.
+type cmpCache struct {
+	mu    sync.Mutex
+	f     CmpFunc
+	size  int
+	items map[cmpArgs]*list.Element // the values of lru are cmpResult
+	lru   *list.List                // the front is the most recently used
+}
+
+func (c *cmpCache) cmp(key1, key2 interface{}) int {
+	args := cmpArgs{key1: key1, key2: key2}
+	c.mu.Lock()
+	if e, ok := c.items[args]; ok {
+		c.lru.MoveToFront(e)
+		res := e.Value.(cmpResult).res
+		c.mu.Unlock()
+		return res
+	}
+	c.mu.Unlock()
+
+	res := c.f(key1, key2) // f may be slow, don't hold the lock
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[args]; ok {
+		return res // cached by another goroutine meanwhile
+	}
+	if c.lru.Len() >= c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(cmpResult).args)
+	}
+	c.items[args] = c.lru.PushFront(cmpResult{args: args, res: res})
+	return res
+}
@@ -0,0 +1,69 @@
+package rbtree
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+func TestCachedCmp(t *testing.T) {
+	calls := 0
+	raw := func(a, b interface{}) int {
+		calls++
+		return intCmp(a, b)
+	}
+	cached := NewCachedCmp(raw, 16)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		a, b := r.Intn(10), r.Intn(10)
+		if got, want := cached(a, b), intCmp(a, b); got != want {
+			t.Fatalf("cmp(%d, %d) got %d, want %d", a, b, got, want)
+		}
+	}
+	if calls >= 10000 {
+		t.Fatalf("raw cmp was called %d times for 10000 comparisons of 100 pairs", calls)
+	}
+
+	tr := New(NewCachedCmp(intCmp, 8))
+	for i := 0; i < 1000; i++ {
+		tr.Put(r.Intn(500), i)
+	}
+	mustValid(t, tr)
+}
+
+// BenchmarkCachedCmp looks up a few hot keys in a tree of strings ordered by a locale-aware collation.
+func BenchmarkCachedCmp(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	words := make([]string, 2000)
+	for i := range words {
+		words[i] = fmt.Sprintf("straße-%c%c%d", 'a'+r.Intn(26), 'A'+r.Intn(26), r.Intn(1000))
+	}
+	hot := words[:50]
+	col := collate.New(language.German)
+
+	for _, c := range []struct {
+		name      string
+		cacheSize int
+	}{{"Raw", 0}, {"Cached", 4096}} {
+		b.Run(c.name, func(b *testing.B) {
+			calls := 0
+			f := NewCachedCmp(func(a, b interface{}) int {
+				calls++
+				return col.CompareString(a.(string), b.(string))
+			}, c.cacheSize)
+			tr := New(f)
+			for _, w := range words {
+				tr.Put(w, nil)
+			}
+			calls = 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tr.Get(hot[i%len(hot)])
+			}
+			b.ReportMetric(float64(calls)/float64(b.N), "cmps/op")
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package rbtree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrCorruptKeys is returned by UnmarshalKeys when data is not produced by MarshalKeys.
+var ErrCorruptKeys = errors.New("rbtree: corrupt keys data")
+
+// KeyCodec converts keys to bytes and back for MarshalKeys and UnmarshalKeys.
+type KeyCodec interface {
+	EncodeKey(key interface{}) ([]byte, error)
+	DecodeKey(data []byte) (interface{}, error)
+}
+
+// MarshalKeys encodes the keys in ASC by codec, values are dropped, it's for a tree used as an ordered set.
+// The format is the count of keys, then the length and the bytes of each key, the numbers are uvarints.
+// O(N)
+func (t *rbTree) MarshalKeys(codec KeyCodec) ([]byte, error) {
+	buf := appendUvarint(nil, uint64(t.len))
+	var err error
+	t.walkAsc(t.root, func(n *node) bool {
+		var b []byte
+		if b, err = codec.EncodeKey(n.key); err != nil {
+			return false
+		}
+		buf = appendUvarint(buf, uint64(len(b)))
+		buf = append(buf, b...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// UnmarshalKeys builds a balanced rbTree ordered by f from the result of MarshalKeys, all values are nil.
+// It returns ErrCorruptKeys if data is malformed or the decoded keys are not in strict ASC by f.
+// O(N)
+func UnmarshalKeys(f CmpFunc, codec KeyCodec, data []byte) (*rbTree, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) { // each key takes at least one byte of length
+		return nil, ErrCorruptKeys
+	}
+	data = data[n:]
+
+	keys := make([]interface{}, count)
+	for i := range keys {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return nil, ErrCorruptKeys
+		}
+		key, err := codec.DecodeKey(data[n : n+int(size)])
+		if err != nil {
+			return nil, fmt.Errorf("rbtree: decoding key %d: %w", i, err)
+		}
+		if i > 0 && f(keys[i-1], key) >= 0 {
+			return nil, ErrCorruptKeys
+		}
+		keys[i] = key
+		data = data[n+int(size):]
+	}
+	if len(data) > 0 {
+		return nil, ErrCorruptKeys
+	}
+
+	return newFromSorted(f, len(keys), func(i int) (key, value interface{}) {
+		return keys[i], nil
+	}), nil
+}
+
+// appendUvarint appends the uvarint of x to buf.
+func appendUvarint(buf []byte, x uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], x)]...)
+}
+
+// ErrInvalidStructure is returned by ImportStructure when the entries don't make a rbTree.
+var ErrInvalidStructure = errors.New("rbtree: invalid structure")
+
+// StructEntry is a node exported by ExportStructure, the links are indexes in the exported entries, -1 means none.
+type StructEntry struct {
+	Key, Value          interface{}
+	Red                 bool
+	Parent, Left, Right int
+}
+
+// ExportStructure returns the nodes in pre-order with their colors and links, so that ImportStructure can rebuild
+// the identical tree without rebalancing. entries[0] is the root.
+// O(N)
+func (t *rbTree) ExportStructure() []StructEntry {
+	res := make([]StructEntry, 0, t.len)
+	index := make(map[*node]int, t.len)
+	t.walkPre(t.root, func(n *node) {
+		e := StructEntry{Key: n.key, Value: n.value, Red: n.color == red, Parent: -1, Left: -1, Right: -1}
+		if n.parent != t.nil {
+			// The parent is exported before its children in pre-order.
+			e.Parent = index[n.parent]
+			if n == n.parent.left {
+				res[e.Parent].Left = len(res)
+			} else {
+				res[e.Parent].Right = len(res)
+			}
+		}
+		index[n] = len(res)
+		res = append(res, e)
+	})
+	return res
+}
+
+// ImportStructure rebuilds the tree exported by ExportStructure with cmp f.
+// It returns ErrInvalidStructure if the links are inconsistent, the keys are not in strict ASC by f,
+// or the colors break the rules of rbTree.
+// O(N)
+func ImportStructure(f CmpFunc, entries []StructEntry) (*rbTree, error) {
+	t := New(f)
+	nodes := make([]*node, len(entries))
+	for i := range entries {
+		nodes[i] = &node{key: entries[i].Key, value: entries[i].Value, color: black}
+		if entries[i].Red {
+			nodes[i].color = red
+		}
+	}
+	link := func(i int) (*node, bool) {
+		if i == -1 {
+			return t.nil, true
+		} else if i < 0 || i >= len(nodes) {
+			return nil, false
+		}
+		return nodes[i], true
+	}
+
+	var root *node
+	for i, e := range entries {
+		n := nodes[i]
+		var ok1, ok2, ok3 bool
+		n.parent, ok1 = link(e.Parent)
+		n.left, ok2 = link(e.Left)
+		n.right, ok3 = link(e.Right)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, ErrInvalidStructure
+		}
+		if n.parent == t.nil {
+			if root != nil {
+				return nil, ErrInvalidStructure
+			}
+			root = n
+		}
+	}
+	// Each link must be mutual, so that every node has a single parent.
+	for _, n := range nodes {
+		if (n.left != t.nil && n.left.parent != n) || (n.right != t.nil && n.right.parent != n) ||
+			(n.parent != t.nil && n.parent.left != n && n.parent.right != n) {
+			return nil, ErrInvalidStructure
+		}
+	}
+	if root == nil {
+		if len(nodes) > 0 {
+			return nil, ErrInvalidStructure
+		}
+		return t, nil
+	}
+
+	t.root, t.len = root, len(nodes)
+	count, ordered := 0, true
+	var prev *node
+	t.walkAsc(t.root, func(n *node) bool {
+		if prev != nil && f(prev.key, n.key) >= 0 {
+			ordered = false
+			return false
+		}
+		prev = n
+		count++
+		return true
+	})
+	if !ordered || count != len(nodes) || t.root.color == red {
+		return nil, ErrInvalidStructure
+	}
+	t.walkPost(t.root, func(n *node) {
+		n.size = n.left.size + n.right.size + 1
+	})
+	if _, ok := t.check(t.root); !ok {
+		return nil, ErrInvalidStructure
+	}
+	t.touchAll(t.root)
+	return t, nil
+}
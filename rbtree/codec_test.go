@@ -0,0 +1,131 @@
+package rbtree
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// structurallyEqual returns whether the trees of a and b have the same shape, colors and key-values.
+func structurallyEqual(a, b *rbTree, na, nb *node) bool {
+	if na == a.nil || nb == b.nil {
+		return na == a.nil && nb == b.nil
+	}
+	return na.key == nb.key && na.value == nb.value && na.color == nb.color && na.size == nb.size &&
+		structurallyEqual(a, b, na.left, nb.left) && structurallyEqual(a, b, na.right, nb.right)
+}
+
+// varintCodec encodes int keys as varints.
+type varintCodec struct{}
+
+func (varintCodec) EncodeKey(key interface{}) ([]byte, error) {
+	return binary.AppendVarint(nil, int64(key.(int))), nil
+}
+
+func (varintCodec) DecodeKey(data []byte) (interface{}, error) {
+	x, n := binary.Varint(data)
+	if n != len(data) {
+		return nil, errors.New("bad varint")
+	}
+	return int(x), nil
+}
+
+func TestMarshalKeys(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tr := New(intCmp)
+	for tr.Len() < 10000 {
+		tr.Put(r.Intn(1000000)-500000, r.Int())
+	}
+	data, err := tr.MarshalKeys(varintCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := UnmarshalKeys(intCmp, varintCodec{}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustValid(t, res)
+	if !reflect.DeepEqual(res.Keys(), tr.Keys()) {
+		t.Fatal("UnmarshalKeys got other keys")
+	}
+
+	// Any truncation breaks the count of keys or a key length.
+	for _, n := range []int{0, 1, 2, len(data) / 2, len(data) - 1} {
+		if res, err := UnmarshalKeys(intCmp, varintCodec{}, data[:n]); err != ErrCorruptKeys || res != nil {
+			t.Fatalf("UnmarshalKeys of %d/%d bytes got %v", n, len(data), err)
+		}
+	}
+	if _, err := UnmarshalKeys(intCmp, varintCodec{}, append(data, 0)); err != ErrCorruptKeys {
+		t.Fatalf("UnmarshalKeys with a trailing byte got %v", err)
+	}
+
+	empty, _ := New(intCmp).MarshalKeys(varintCodec{})
+	if res, err := UnmarshalKeys(intCmp, varintCodec{}, empty); err != nil || res.Len() != 0 {
+		t.Fatalf("UnmarshalKeys of an empty tree got %v", err)
+	}
+}
+
+func TestStructureRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 2, 3, 10, 100, 1000} {
+		tr := New(intCmp)
+		for tr.Len() < n {
+			tr.Put(r.Intn(n*3), r.Int())
+			if r.Intn(4) == 0 {
+				tr.Delete(r.Intn(n * 3))
+			}
+		}
+		entries := tr.ExportStructure()
+		if len(entries) != tr.Len() {
+			t.Fatalf("ExportStructure got %d entries, want %d", len(entries), tr.Len())
+		}
+		got, err := ImportStructure(intCmp, entries)
+		if err != nil {
+			t.Fatalf("ImportStructure of %d entries: %v", n, err)
+		}
+		mustValid(t, got)
+		if !structurallyEqual(tr, got, tr.root, got.root) {
+			t.Fatalf("ImportStructure of %d entries built another tree", n)
+		}
+		if !reflect.DeepEqual(got.ExportStructure(), entries) {
+			t.Fatalf("ExportStructure of the imported tree of %d entries differs", n)
+		}
+	}
+}
+
+func TestImportStructureInvalid(t *testing.T) {
+	// valid is 2 black with the children 1 and 3 red.
+	valid := func() []StructEntry {
+		return []StructEntry{
+			{Key: 2, Parent: -1, Left: 1, Right: 2},
+			{Key: 1, Red: true, Parent: 0, Left: -1, Right: -1},
+			{Key: 3, Red: true, Parent: 0, Left: -1, Right: -1},
+		}
+	}
+	if _, err := ImportStructure(intCmp, valid()); err != nil {
+		t.Fatal(err)
+	}
+	for name, change := range map[string]func(e []StructEntry) []StructEntry{
+		"bad parent index": func(e []StructEntry) []StructEntry { e[1].Parent = 5; return e },
+		"bad child index":  func(e []StructEntry) []StructEntry { e[0].Right = -2; return e },
+		"one-way link":     func(e []StructEntry) []StructEntry { e[2].Parent = 1; return e },
+		"two roots":        func(e []StructEntry) []StructEntry { e[2].Parent, e[0].Right = -1, -1; return e },
+		"no root": func(e []StructEntry) []StructEntry {
+			e[0].Parent, e[1].Left = 1, 0
+			return e
+		},
+		"unordered keys": func(e []StructEntry) []StructEntry { e[1].Key, e[2].Key = 3, 1; return e },
+		"red root":       func(e []StructEntry) []StructEntry { e[0].Red = true; return e },
+		"red-red": func(e []StructEntry) []StructEntry {
+			e[1].Left = 3
+			return append(e, StructEntry{Key: 0, Red: true, Parent: 1, Left: -1, Right: -1})
+		},
+		"unequal black height": func(e []StructEntry) []StructEntry { e[2].Red = false; return e },
+	} {
+		if tr, err := ImportStructure(intCmp, change(valid())); err != ErrInvalidStructure || tr != nil {
+			t.Fatalf("%s: ImportStructure got %v, want ErrInvalidStructure", name, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package rbtree
+
+import "errors"
+
+// ErrStaleHandle is returned when a Handle is used after its key was deleted, or with another tree.
+var ErrStaleHandle = errors.New("rbtree: stale handle")
+
+// Handle refers to the node of a key, so that its value can be read and updated without searching again.
+// It is returned by PutH of a tree created by NewWithHandles, and becomes stale after the key is deleted.
+type Handle struct {
+	t *rbTree
+	n *node
+}
+
+// NewWithHandles returns a rbTree which supports PutH, SetValue and GetValue.
+func NewWithHandles(f CmpFunc) *rbTree {
+	t := New(f)
+	t.handles = true
+	return t
+}
+
+// PutH stores the key-value pair like Put, and returns the Handle of key.
+// It panics if the tree was not created by NewWithHandles.
+// O(logN)
+func (t *rbTree) PutH(key interface{}, value interface{}) Handle {
+	if !t.handles {
+		panic("rbtree: PutH needs a tree created by NewWithHandles")
+	}
+	return Handle{t: t, n: t.put(key, value)}
+}
+
+// SetValue replaces the value of the key referred by h, it returns ErrStaleHandle if h is stale.
+// O(1)
+func (t *rbTree) SetValue(h Handle, value interface{}) error {
+	if !t.valid(h) {
+		return ErrStaleHandle
+	}
+	h.n.value = value
+	t.touch(h.n)
+	return nil
+}
+
+// GetValue returns the value of the key referred by h, it returns ErrStaleHandle if h is stale.
+// O(1)
+func (t *rbTree) GetValue(h Handle) (interface{}, error) {
+	if !t.valid(h) {
+		return nil, ErrStaleHandle
+	}
+	return h.n.value, nil
+}
+
+// valid reports whether h refers to a node in t, deleted nodes have nil parent.
+func (t *rbTree) valid(h Handle) bool {
+	return h.t == t && h.n != nil && h.n.parent != nil
+}
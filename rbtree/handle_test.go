@@ -0,0 +1,67 @@
+package rbtree
+
+import "testing"
+
+func TestHandle(t *testing.T) {
+	tr := NewWithHandles(intCmp)
+	hs := make(map[int]Handle)
+	for i := 0; i < 100; i++ {
+		hs[i] = tr.PutH(i, i)
+	}
+	// A handle stays valid across Puts of other keys, Put and SetValue of its key, and Rebuild.
+	tr.Put(5, 50)
+	if v, err := tr.GetValue(hs[5]); err != nil || v != 50 {
+		t.Fatalf("GetValue after Put got %v %v, want 50", v, err)
+	}
+	if err := tr.SetValue(hs[5], 500); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := tr.Get(5); v != 500 {
+		t.Fatalf("Get after SetValue got %v, want 500", v)
+	}
+	if h := tr.PutH(5, 5000); h != hs[5] {
+		t.Fatal("PutH of a present key returned another handle")
+	}
+	tr.Rebuild()
+	if v, err := tr.GetValue(hs[5]); err != nil || v != 5000 {
+		t.Fatalf("GetValue after Rebuild got %v %v, want 5000", v, err)
+	}
+
+	// A handle is stale after its key is deleted, in every way, even if the key is put again.
+	tr.Delete(1)
+	tr.PopMin()
+	tr.PopMax()
+	tr.RetainIf(func(key, _ interface{}) bool { return key != 10 })
+	tr.DeleteAt(20)
+	tr.Put(1, 1)
+	for _, k := range []int{0, 1, 10, 20, 99} {
+		if _, err := tr.GetValue(hs[k]); err != ErrStaleHandle {
+			t.Fatalf("GetValue after deleting %d got %v, want ErrStaleHandle", k, err)
+		}
+		if err := tr.SetValue(hs[k], 0); err != ErrStaleHandle {
+			t.Fatalf("SetValue after deleting %d got %v, want ErrStaleHandle", k, err)
+		}
+	}
+	if v, _ := tr.Get(1); v != 1 {
+		t.Fatalf("Get(1) got %v, want 1", v)
+	}
+	if _, err := NewWithHandles(intCmp).GetValue(hs[5]); err != ErrStaleHandle {
+		t.Fatalf("GetValue with another tree got %v, want ErrStaleHandle", err)
+	}
+
+	// ReplaceAll drops all the nodes, even of the keys in the new contents.
+	tr.ReplaceAll(intPairs(2, 3, 5))
+	for _, k := range []int{2, 3, 5, 50} {
+		if _, err := tr.GetValue(hs[k]); err != ErrStaleHandle {
+			t.Fatalf("GetValue of %d after ReplaceAll got %v, want ErrStaleHandle", k, err)
+		}
+	}
+
+	// ReplaceAll with no pairs empties the tree.
+	h := tr.PutH(7, 7)
+	tr.ReplaceAll(nil)
+	if _, err := tr.GetValue(h); err != ErrStaleHandle || tr.Len() != 0 {
+		t.Fatalf("GetValue after emptying got %v, want ErrStaleHandle", err)
+	}
+	mustValid(t, tr)
+}
@@ -0,0 +1,91 @@
+package rbtree
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+// HashRing is a consistent hashing ring, the positions of the virtual nodes are the keys of a rbTree in ASC of uint64,
+// and a key is owned by the node of the first position clockwise from the hash of the key.
+// It's not safe for concurrent use.
+type HashRing struct {
+	t         *rbTree
+	positions map[string][]uint64 // id -> the positions taken by the node
+}
+
+// NewHashRing returns an empty HashRing.
+func NewHashRing() *HashRing {
+	return &HashRing{
+		t: New(func(key1, key2 interface{}) int {
+			k1, k2 := key1.(uint64), key2.(uint64)
+			if k1 < k2 {
+				return -1
+			} else if k1 > k2 {
+				return 1
+			}
+			return 0
+		}),
+		positions: make(map[string][]uint64),
+	}
+}
+
+// AddNode adds the node id with vnodes virtual nodes, more virtual nodes spread the keys more evenly.
+// If id is already in the ring, its virtual nodes are replaced. A position already taken by another node is skipped.
+// It panics if vnodes <= 0, a node without positions would own no key.
+// O(vnodes*logN)
+func (r *HashRing) AddNode(id string, vnodes int) {
+	if vnodes <= 0 {
+		panic("rbtree: HashRing.AddNode needs vnodes > 0")
+	}
+	r.RemoveNode(id)
+	var positions []uint64
+	for i := 0; i < vnodes; i++ {
+		pos := hash64(id + "#" + strconv.Itoa(i))
+		if r.t.search(pos) == r.t.nil {
+			r.t.put(pos, id)
+			positions = append(positions, pos)
+		}
+	}
+	r.positions[id] = positions
+}
+
+// RemoveNode removes the node id and its virtual nodes, the keys owned by it move to the next nodes on the ring,
+// the other keys stay. It does nothing if id is not in the ring.
+// O(vnodes*logN)
+func (r *HashRing) RemoveNode(id string) {
+	for _, pos := range r.positions[id] {
+		r.t.Delete(pos)
+	}
+	delete(r.positions, id)
+}
+
+// Get returns the id of the node owning key, or "" if the ring is empty.
+// O(logN)
+func (r *HashRing) Get(key string) string {
+	p := r.t.ceiling(hash64(key))
+	if p == r.t.nil {
+		p = r.t.min(r.t.root) // wrap around the ring
+	}
+	if p == r.t.nil {
+		return ""
+	}
+	return p.value.(string)
+}
+
+// Len returns the count of nodes in the ring.
+func (r *HashRing) Len() int {
+	return len(r.positions)
+}
+
+// hash64 is FNV-1a followed by the finalizer of MurmurHash3, FNV alone spreads similar strings poorly on the ring.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
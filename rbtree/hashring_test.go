@@ -0,0 +1,67 @@
+package rbtree
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	r := NewHashRing()
+	if got := r.Get("key"); got != "" {
+		t.Fatalf("Get on an empty ring got %q", got)
+	}
+	ids := []string{"a", "b", "c", "d"}
+	for _, id := range ids {
+		r.AddNode(id, 200)
+	}
+	r.AddNode("a", 200) // replaces the virtual nodes of a
+	if r.Len() != len(ids) || r.t.Len() != len(ids)*200 {
+		t.Fatalf("Len %d with %d positions, want %d with %d", r.Len(), r.t.Len(), len(ids), len(ids)*200)
+	}
+	mustValid(t, r.t)
+
+	// With 200 virtual nodes each, every node owns about a quarter of the keys.
+	const keys = 40000
+	owners := make(map[string]string, keys)
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		k := "key" + strconv.Itoa(i)
+		owners[k] = r.Get(k)
+		counts[owners[k]]++
+	}
+	for _, id := range ids {
+		if c := counts[id]; c < keys/len(ids)*7/10 || c > keys/len(ids)*13/10 {
+			t.Fatalf("unbalanced ring: %v", counts)
+		}
+	}
+
+	// Removing a node reassigns only its own keys.
+	r.RemoveNode("b")
+	if r.Len() != len(ids)-1 {
+		t.Fatalf("Len got %d, want %d", r.Len(), len(ids)-1)
+	}
+	for k, owner := range owners {
+		got := r.Get(k)
+		if got == "b" || (owner != "b" && got != owner) {
+			t.Fatalf("Get(%q) got %q after removing b, was %q", k, got, owner)
+		}
+	}
+	mustValid(t, r.t)
+}
+
+func TestHashRingAddNodePanics(t *testing.T) {
+	for _, vnodes := range []int{0, -1} {
+		func() {
+			r := NewHashRing()
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("AddNode with %d vnodes didn't panic", vnodes)
+				}
+				if r.Len() != 0 {
+					t.Fatalf("AddNode with %d vnodes recorded the node", vnodes)
+				}
+			}()
+			r.AddNode("a", vnodes)
+		}()
+	}
+}
@@ -0,0 +1,116 @@
+package rbtree
+
+// Iterator walks a rbTree in ASC lazily, one key-value per Next.
+// The tree must not be modified during the walk, except by Iterator.Remove.
+type Iterator struct {
+	t    *rbTree
+	next *node // the node to return by Next
+	last *node // the node returned by the last Next, nil if none or removed
+}
+
+// Iterator returns an Iterator positioned before the minimum key.
+// O(logN)
+func (t *rbTree) Iterator() *Iterator {
+	return &Iterator{t: t, next: t.min(t.root)}
+}
+
+// Next returns the next key-value in ASC, ok is false if the walk is over.
+// O(1) amortized
+func (it *Iterator) Next() (key, value interface{}, ok bool) {
+	if it.next == it.t.nil {
+		it.last = nil
+		return nil, nil, false
+	}
+	it.last = it.next
+	it.next = it.t.successor(it.next)
+	return it.last.key, it.last.value, true
+}
+
+// Remove deletes the key-value just returned by Next, the walk continues from its successor.
+// Only the key-value just returned can be removed, and only once. It panics otherwise.
+// O(logN)
+func (it *Iterator) Remove() {
+	if it.last == nil {
+		panic("rbtree: Iterator.Remove without a key-value returned by Next")
+	}
+	// delete moves nodes instead of copying keys, so it.next, captured before, is still the successor.
+	it.t.delete(it.last)
+	it.last = nil
+}
+
+// Cursor is a position in a rbTree which moves in both directions, it's positioned by Seek, First or Last.
+// The cursor holds a node, so the tree can be modified between the moves, the cursor stays on its key and moves
+// over the keys in the tree at the time of the move. But if the key at the cursor is deleted, the cursor must be
+// positioned again before the next move.
+type Cursor struct {
+	t *rbTree
+	n *node // t.nil if the cursor is not on a key
+}
+
+// Cursor returns a Cursor which is not on any key yet.
+func (t *rbTree) Cursor() *Cursor {
+	return &Cursor{t: t, n: t.nil}
+}
+
+// GetRange returns a Cursor at the minimum key >= key, it's not on any key if there is none.
+// It's the same as Cursor followed by Seek, for scans from a key: for c := t.GetRange(key); c.Valid(); c.Next() {}
+// O(logN)
+func (t *rbTree) GetRange(key interface{}) *Cursor {
+	c := t.Cursor()
+	c.Seek(key)
+	return c
+}
+
+// Seek moves the cursor to the minimum key >= key, it returns false if there is none.
+// O(logN)
+func (c *Cursor) Seek(key interface{}) bool {
+	c.n = c.t.ceiling(key)
+	return c.Valid()
+}
+
+// First moves the cursor to the minimum key, it returns false if the tree is empty.
+// O(logN)
+func (c *Cursor) First() bool {
+	c.n = c.t.min(c.t.root)
+	return c.Valid()
+}
+
+// Last moves the cursor to the maximum key, it returns false if the tree is empty.
+// O(logN)
+func (c *Cursor) Last() bool {
+	c.n = c.t.max(c.t.root)
+	return c.Valid()
+}
+
+// Next moves the cursor to the next key in ASC, it returns false if there is none, the cursor is not on any key then.
+// O(1) amortized
+func (c *Cursor) Next() bool {
+	if c.n != c.t.nil {
+		c.n = c.t.successor(c.n)
+	}
+	return c.Valid()
+}
+
+// Prev moves the cursor to the previous key in ASC, it returns false if there is none, the cursor is not on any key then.
+// O(1) amortized
+func (c *Cursor) Prev() bool {
+	if c.n != c.t.nil {
+		c.n = c.t.predecessor(c.n)
+	}
+	return c.Valid()
+}
+
+// Valid returns whether the cursor is on a key.
+func (c *Cursor) Valid() bool {
+	return c.n != c.t.nil
+}
+
+// Key returns the key at the cursor, or nil if the cursor is not on any key.
+func (c *Cursor) Key() interface{} {
+	return c.n.key
+}
+
+// Value returns the value at the cursor, or nil if the cursor is not on any key.
+func (c *Cursor) Value() interface{} {
+	return c.n.value
+}
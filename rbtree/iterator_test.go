@@ -0,0 +1,109 @@
+package rbtree
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	tr := New(intCmp)
+	c := tr.Cursor()
+	if c.Valid() || c.First() || c.Seek(1) || c.Next() || c.Key() != nil || tr.GetRange(1).Valid() {
+		t.Fatal("a cursor of an empty tree is on a key")
+	}
+	for _, k := range []int{10, 20, 30, 40} {
+		tr.Put(k, k+1)
+	}
+
+	c = tr.GetRange(15)
+	if !c.Valid() || c.Key() != 20 || c.Value() != 21 {
+		t.Fatalf("GetRange(15) got %v, want 20", c.Key())
+	}
+	if !c.Next() || c.Key() != 30 {
+		t.Fatalf("Next got %v, want 30", c.Key())
+	}
+	if !c.Prev() || !c.Prev() || c.Key() != 10 {
+		t.Fatalf("Prev twice got %v, want 10", c.Key())
+	}
+	// Moving past the first key leaves the cursor on no key, it doesn't move back.
+	if c.Prev() || c.Valid() || c.Next() || c.Key() != nil {
+		t.Fatal("the cursor is still on a key after Prev past the first key")
+	}
+	if c.Seek(41) || tr.GetRange(41).Valid() {
+		t.Fatal("Seek(41) found a key after the last key")
+	}
+	if !c.Seek(40) || c.Next() {
+		t.Fatal("Next past the last key found a key")
+	}
+	if !c.Seek(10) || c.Key() != 10 || c.Prev() {
+		t.Fatal("Prev before the first key found a key")
+	}
+	if !c.Last() || c.Key() != 40 || !c.First() || c.Key() != 10 {
+		t.Fatalf("Last and First got %v", c.Key())
+	}
+
+	// The cursor moves over the keys in the tree at the time of the move.
+	c.Seek(20)
+	tr.Put(25, 0)
+	tr.Delete(30)
+	tr.Delete(10)
+	for _, want := range []int{25, 40} {
+		if !c.Next() || c.Key() != want {
+			t.Fatalf("Next got %v, want %d", c.Key(), want)
+		}
+	}
+	for _, want := range []int{25, 20} {
+		if !c.Prev() || c.Key() != want {
+			t.Fatalf("Prev got %v, want %d", c.Key(), want)
+		}
+	}
+	if c.Prev() {
+		t.Fatalf("Prev got %v, want none", c.Key())
+	}
+}
+
+// mustPanic fails tb if fn doesn't panic.
+func mustPanic(tb testing.TB, name string, fn func()) {
+	tb.Helper()
+	defer func() {
+		if recover() == nil {
+			tb.Fatalf("%s didn't panic", name)
+		}
+	}()
+	fn()
+}
+
+func TestIteratorRemove(t *testing.T) {
+	tr := New(intCmp)
+	var want []interface{}
+	for i := 0; i < 200; i++ {
+		tr.Put(i, i)
+		if i%2 == 1 {
+			want = append(want, i)
+		}
+	}
+
+	it := tr.Iterator()
+	mustPanic(t, "Remove before Next", it.Remove)
+	var got []interface{}
+	for i := 0; ; i++ {
+		key, _, ok := it.Next()
+		if !ok {
+			break
+		}
+		got = append(got, key)
+		if i%2 == 0 {
+			it.Remove()
+			mustPanic(t, "a second Remove", it.Remove)
+			mustValid(t, tr)
+		}
+	}
+	mustPanic(t, "Remove after the walk is over", it.Remove)
+
+	if len(got) != 200 {
+		t.Fatalf("the walk returned %d keys, want 200", len(got))
+	}
+	if !reflect.DeepEqual(tr.Keys(), want) {
+		t.Fatalf("Keys after removing every other key got %v, want %v", tr.Keys(), want)
+	}
+}
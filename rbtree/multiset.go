@@ -0,0 +1,84 @@
+package rbtree
+
+import "github.com/shengmingzhu/datastructures/pair"
+
+// MultiSet is an ordered multiset, it is a rbTree whose values are the counts of the keys.
+// A key in the multiset has a count > 0.
+type MultiSet struct {
+	t     *rbTree
+	total int
+}
+
+func NewMultiSet(f CmpFunc) *MultiSet {
+	return &MultiSet{t: New(f)}
+}
+
+// Len returns the count of distinct keys.
+func (s *MultiSet) Len() int {
+	return s.t.Len()
+}
+
+// Total returns the sum of the counts of all keys.
+// O(1)
+func (s *MultiSet) Total() int {
+	return s.total
+}
+
+// AddCount adds n to the count of key, the key is added with count n if it's not in the multiset.
+// It does nothing if n <= 0.
+// O(logN)
+func (s *MultiSet) AddCount(key interface{}, n int) {
+	if n <= 0 {
+		return
+	}
+	if p := s.t.search(key); p != s.t.nil {
+		p.value = p.value.(int) + n
+		s.t.touch(p)
+	} else {
+		s.t.put(key, n)
+	}
+	s.total += n
+}
+
+// Count returns the count of key, 0 if key is not in the multiset.
+// O(logN)
+func (s *MultiSet) Count(key interface{}) int {
+	if p := s.t.search(key); p != s.t.nil {
+		return p.value.(int)
+	}
+	return 0
+}
+
+// Remove subtracts n from the count of key, the key is deleted if its count drops to 0 or below.
+// It returns the count actually removed, which is less than n if the count of key is less than n.
+// O(logN)
+func (s *MultiSet) Remove(key interface{}, n int) int {
+	p := s.t.search(key)
+	if p == s.t.nil || n <= 0 {
+		return 0
+	}
+	count := p.value.(int)
+	if n >= count {
+		s.t.delete(p)
+		n = count
+	} else {
+		p.value = count - n
+		s.t.touch(p)
+	}
+	s.total -= n
+	return n
+}
+
+// Range returns the keys in [minKey, maxKey] with their counts in ASC.
+// Pair.First: Key, Pair.Second: Count
+// O(N)
+func (s *MultiSet) Range(minKey, maxKey interface{}) []pair.Pair {
+	return s.t.Range(minKey, maxKey)
+}
+
+// RangeAll returns all keys with their counts in ASC.
+// Pair.First: Key, Pair.Second: Count
+// O(N)
+func (s *MultiSet) RangeAll() []pair.Pair {
+	return s.t.RangeAll()
+}
@@ -0,0 +1,41 @@
+package rbtree
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shengmingzhu/datastructures/pair"
+)
+
+func TestMultiSet(t *testing.T) {
+	s := NewMultiSet(intCmp)
+	s.AddCount(1, 2)
+	s.AddCount(2, 1)
+	s.AddCount(1, 3)
+	s.AddCount(3, 0) // ignored
+	s.AddCount(3, -1)
+	if s.Count(1) != 5 || s.Count(2) != 1 || s.Count(3) != 0 || s.Len() != 2 || s.Total() != 6 {
+		t.Fatalf("counts got %v with Total %d", s.RangeAll(), s.Total())
+	}
+
+	_, v, _ := s.t.GetWithVersion(1)
+	if n := s.Remove(1, 2); n != 2 || s.Count(1) != 3 {
+		t.Fatalf("Remove(1, 2) got %d, count %d", n, s.Count(1))
+	}
+	// A change of count gives the key a new version.
+	if got := s.t.ChangedSince(v); !reflect.DeepEqual(got, []pair.Pair{{First: 1, Second: 3}}) {
+		t.Fatalf("ChangedSince got %v", got)
+	}
+
+	// Removing past zero deletes the key, and removes only its count.
+	if n := s.Remove(1, 10); n != 3 || s.Count(1) != 0 || s.Len() != 1 || s.Total() != 1 {
+		t.Fatalf("Remove(1, 10) got %d, left %v with Total %d", n, s.RangeAll(), s.Total())
+	}
+	if n := s.Remove(1, 1); n != 0 {
+		t.Fatalf("Remove of an absent key got %d", n)
+	}
+	if n := s.Remove(2, 0); n != 0 || s.Count(2) != 1 {
+		t.Fatalf("Remove(2, 0) got %d", n)
+	}
+	mustValid(t, s.t)
+}
@@ -1,16 +1,36 @@
 package rbtree
 
 import (
+	"context"
 	"fmt"
 	"github.com/shengmingzhu/datastructures/pair"
+	"math"
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"sort"
 	"strings"
 )
 
 type rbTree struct {
-	len  int
-	root *node
-	cmp  CmpFunc // cmp(key1, key2). It returns 0 if key1 == key2, returns 1 if key1 > key2, returns -1 if key1 < key2.
-	nil  *node
+	len     int
+	root    *node
+	cmp     CmpFunc // cmp(key1, key2). It returns 0 if key1 == key2, returns 1 if key1 > key2, returns -1 if key1 < key2.
+	nil     *node
+	version uint64       // the last version given to a node by Put
+	keyKind reflect.Kind // the reflect.Kind of the first non-nil key stored, see KeyKind
+	handles bool         // if true, deleted nodes are marked so that their Handles become stale
+
+	sentinel interface{} // the key returned by Min, Max, PopMin and PopMax if the tree is empty, see NewWithSentinel
+
+	metrics Metrics
+
+	maxEntries int // if > 0, Put evicts a key-value by evict when the tree grows beyond it, see NewCapped
+	evict      EvictPolicy
+	onEvict    func(key, value interface{})
+
+	countOverwrites bool // if true, overwrites counts the times Put replaced the value of an existing key
+	overwrites      int
 }
 
 // CmpFunc such as CmpFunc(key1, key2).
@@ -23,11 +43,141 @@ Example:
 */
 type CmpFunc func(interface{}, interface{}) int
 
+// ByField returns a CmpFunc comparing keys by the projections in ASC, later projections break ties of earlier ones.
+// For example, ByField(byAge, byID) orders by age, then by id if ages are same.
+// To order a field in DESC, project it to its negative.
+func ByField(projections ...func(key interface{}) int64) CmpFunc {
+	return func(key1, key2 interface{}) int {
+		for _, project := range projections {
+			v1, v2 := project(key1), project(key2)
+			if v1 < v2 {
+				return -1
+			} else if v1 > v2 {
+				return 1
+			}
+		}
+		return 0
+	}
+}
+
+// CompositeKey is a key of several elements, such as CompositeKey{lastName, firstName, id}, see CompositeCmp.
+// It's a slice, so it's not comparable by ==, a CmpFunc wrapped by NewCachedCmp panics on it.
+type CompositeKey []interface{}
+
+// CompositeCmp returns a CmpFunc comparing CompositeKeys element by element, the i-th elements are compared by elemCmps[i].
+// If a key is a prefix of the other, the shorter one is less.
+// It panics if a key has more elements than elemCmps.
+func CompositeCmp(elemCmps ...CmpFunc) CmpFunc {
+	return func(key1, key2 interface{}) int {
+		k1, k2 := key1.(CompositeKey), key2.(CompositeKey)
+		for i := 0; i < len(k1) && i < len(k2); i++ {
+			if c := elemCmps[i](k1[i], k2[i]); c != 0 {
+				return c
+			}
+		}
+		return len(k1) - len(k2)
+	}
+}
+
+// Entry is a key-value stored in rbTree.
+type Entry struct {
+	Key   interface{}
+	Value interface{}
+}
+
 func New(f CmpFunc) *rbTree {
 	nilNode := &node{color: black}
 	return &rbTree{len: 0, root: nilNode, cmp: f, nil: nilNode}
 }
 
+// NewWithSentinel returns a rbTree whose Min, Max, PopMin and PopMax return sentinelKey if the tree is empty,
+// so that an empty tree can't be confused with a key which is nil. sentinelKey must not be put into the tree.
+// MinOk and MaxOk are the alternatives without a sentinel.
+func NewWithSentinel(f CmpFunc, sentinelKey interface{}) *rbTree {
+	t := New(f)
+	t.sentinel = sentinelKey
+	return t
+}
+
+// EvictPolicy decides which key-value a capped tree evicts, see NewCapped.
+type EvictPolicy uint8
+
+const (
+	EvictMin EvictPolicy = iota // evict the minimum key
+	EvictMax                    // evict the maximum key
+)
+
+// NewCapped returns a rbTree holding at most maxEntries key-values, it's a bounded ordered buffer.
+// When Put of a new key makes the tree grow beyond maxEntries, the minimum or maximum key-value is deleted by policy,
+// even if it is the key just put, then onEvict is called with it if onEvict is not nil.
+// For example, EvictMin keeps the greatest maxEntries keys.
+func NewCapped(f CmpFunc, maxEntries int, policy EvictPolicy, onEvict func(key, value interface{})) *rbTree {
+	if maxEntries <= 0 {
+		panic("rbtree: NewCapped needs maxEntries > 0")
+	}
+	t := New(f)
+	t.maxEntries, t.evict, t.onEvict = maxEntries, policy, onEvict
+	return t
+}
+
+// NewCountingOverwrites returns a rbTree which counts the times Put replaced the value of an existing key,
+// see OverwriteCount. It helps to find unexpected key collisions.
+func NewCountingOverwrites(f CmpFunc) *rbTree {
+	t := New(f)
+	t.countOverwrites = true
+	return t
+}
+
+// NewFromPreOrder rebuilds the tree of the given shape from the result of PreOrderColors.
+// It panics if pairs is not the pre-order of a binary search tree by f or the colors don't make a rbTree.
+// O(N)
+func NewFromPreOrder(f CmpFunc, pairs []pair.Pair, isRed []bool) *rbTree {
+	if len(isRed) != len(pairs) {
+		panic("rbtree: NewFromPreOrder needs a color for each pair")
+	}
+	t := New(f)
+	pos := 0
+	t.root = t.buildPreOrder(pairs, isRed, &pos, nil, nil, t.nil)
+	t.len = pos
+	if _, ok := t.check(t.root); pos != len(pairs) || !ok || t.root.color == red || !t.IsOrdered(f) {
+		panic("rbtree: NewFromPreOrder got an invalid pre-order")
+	}
+	t.touchAll(t.root)
+	return t
+}
+
+// NewFromSortedDesc builds a balanced rbTree from pairs which are sorted in DESC by f without duplicate keys,
+// such as the result of RangeAllDesc or a DESC query. The order of pairs is not checked.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func NewFromSortedDesc(f CmpFunc, pairs []pair.Pair) *rbTree {
+	last := len(pairs) - 1
+	return newFromSorted(f, len(pairs), func(i int) (key, value interface{}) {
+		return pairs[last-i].First, pairs[last-i].Second
+	})
+}
+
+// FromMap builds a balanced rbTree from the entries of m, it sorts them and builds the tree in one pass.
+// If some keys of m are the same under f, only one of them is kept, which one is unspecified.
+// O(NlogN)
+func FromMap(f CmpFunc, m map[interface{}]interface{}) *rbTree {
+	pairs := make([]pair.Pair, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, pair.Pair{First: k, Second: v})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return f(pairs[i].First, pairs[j].First) < 0
+	})
+	res := pairs[:0]
+	for i := range pairs {
+		if i > 0 && f(pairs[i].First, res[len(res)-1].First) == 0 {
+			continue
+		}
+		res = append(res, pairs[i])
+	}
+	return newFromSortedPairs(f, res)
+}
+
 func (t *rbTree) Len() int {
 	return t.len
 }
@@ -36,6 +186,28 @@ func (t *rbTree) IsEmpty() bool {
 	return t.len == 0
 }
 
+// OverwriteCount returns the times Put replaced the value of an existing key.
+// It is always 0 unless the tree was created by NewCountingOverwrites.
+func (t *rbTree) OverwriteCount() int {
+	return t.overwrites
+}
+
+// Cmp returns the CmpFunc of the tree, so that callers can compare keys the same way as the tree does.
+func (t *rbTree) Cmp() CmpFunc {
+	return t.cmp
+}
+
+// KeyKind returns the reflect.Kind of the keys, or reflect.Invalid if the tree is empty or only has nil keys.
+// It assumes all keys have the same type, the kind is cached when the first non-nil key is stored by Put or
+// a bulk build, and kept after the tree is emptied.
+// O(1)
+func (t *rbTree) KeyKind() reflect.Kind {
+	if t.len == 0 {
+		return reflect.Invalid
+	}
+	return t.keyKind
+}
+
 // Search returns the value to key, or nil if not found.
 // For example: if value, ok := t.Search(key); ok { value found }
 // O(logN)
@@ -48,18 +220,68 @@ func (t *rbTree) Get(key interface{}) (value interface{}, ok bool) {
 	}
 }
 
+// GetMulti returns the key-values of the keys found in rbTree, absent keys are skipped.
+// The result is in ASC of the tree's key order rather than the order of keys, and a key repeated in keys appears once,
+// so that it can be merged with a sorted scan directly.
+// Pair.First: Key, Pair.Second: Value
+// O(MlogN), M = len(keys)
+func (t *rbTree) GetMulti(keys []interface{}) []pair.Pair {
+	nodes := make([]*node, 0, len(keys))
+	for _, key := range keys {
+		if p := t.search(key); p != t.nil {
+			nodes = append(nodes, p)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return t.cmp(nodes[i].key, nodes[j].key) < 0
+	})
+
+	res := make([]pair.Pair, 0, len(nodes))
+	for i, p := range nodes {
+		if i > 0 && p == nodes[i-1] {
+			continue // duplicate key
+		}
+		res = append(res, pair.Pair{First: p.key, Second: p.value})
+	}
+	return res
+}
+
 // Put stores the key-value pair into rbTree.
 // 1. If there is already a same key in rbTree, it will replace the value.
 // 2. Otherwise, it will insert a new node with the key-value.
 // O(logN)
 func (t *rbTree) Put(key interface{}, value interface{}) {
+	t.put(key, value)
+}
+
+// PutKeepKey is Put which guarantees that if key is already in the tree, the stored key instance is kept
+// and only the value is replaced, so the stored key is stable, e.g. for interning.
+// O(logN)
+func (t *rbTree) PutKeepKey(key interface{}, value interface{}) {
+	t.put(key, value)
+}
+
+// PutReplaceKey is Put which also replaces the stored key instance with key if an equal key is already in the tree.
+// O(logN)
+func (t *rbTree) PutReplaceKey(key interface{}, value interface{}) {
+	t.put(key, value).key = key
+}
+
+// put stores the key-value pair and returns the node of key.
+// On a capped tree, the returned node may be the one evicted by this put, it's out of the tree then.
+// O(logN)
+func (t *rbTree) put(key interface{}, value interface{}) *node {
 	y := t.nil
 	x := t.root
 	for x != t.nil {
 		y = x
 		if t.cmp(x.key, key) == 0 {
 			x.value = value
-			return // if found, save value and return
+			t.touch(x)
+			if t.countOverwrites {
+				t.overwrites++
+			}
+			return x // if found, save value and return
 		} else if t.cmp(x.key, key) > 0 {
 			x = x.left
 		} else {
@@ -69,6 +291,7 @@ func (t *rbTree) Put(key interface{}, value interface{}) {
 
 	// if not found, we insert a new node
 	z := t.newNodeForInsert(key, value, y)
+	t.touch(z)
 	if y == t.nil {
 		t.root = z
 	} else if t.cmp(y.key, key) > 0 {
@@ -77,8 +300,148 @@ func (t *rbTree) Put(key interface{}, value interface{}) {
 		y.right = z
 	}
 	t.len++
+	for p := y; p != t.nil; p = p.parent {
+		p.size++
+	}
 
 	t.fixupInsert(z)
+	if t.maxEntries > 0 && t.len > t.maxEntries {
+		t.evictOne()
+	}
+	return z
+}
+
+// ReplaceAll replaces all key-values of the tree with pairs, which are sorted in ASC by cmp without duplicate keys,
+// such as the result of RangeAll. The order of pairs is not checked. The new contents are balanced like NewFromSortedDesc,
+// and all of them get new versions, so that ChangedSince reports them.
+// Pair.First: Key, Pair.Second: Value
+// O(M), M = len(pairs)
+func (t *rbTree) ReplaceAll(pairs []pair.Pair) {
+	t.swapRoot(t.buildPairs(pairs), len(pairs))
+}
+
+// buildPairs builds a balanced subtree of pairs, which are sorted in ASC, with t.nil, and returns its root.
+// It doesn't modify t.
+func (t *rbTree) buildPairs(pairs []pair.Pair) *node {
+	return t.buildSorted(func(i int) (key, value interface{}) {
+		return pairs[i].First, pairs[i].Second
+	}, 0, len(pairs), 1, bits.Len(uint(len(pairs)+1)), t.nil)
+}
+
+// swapRoot replaces the contents of t with the subtree of root which has n nodes, they get new versions.
+func (t *rbTree) swapRoot(root *node, n int) {
+	old := t.root
+	t.root, t.len = root, n
+	t.touchAll(root)
+	if t.handles {
+		t.walkPost(old, func(n *node) {
+			n.parent = nil // n is out of the tree, mark it for Handle.
+		})
+	}
+	for t.maxEntries > 0 && t.len > t.maxEntries {
+		t.evictOne()
+	}
+}
+
+// evictOne deletes the key-value chosen by t.evict, and calls t.onEvict with it.
+func (t *rbTree) evictOne() {
+	var n *node
+	if t.evict == EvictMax {
+		n = t.max(t.root)
+	} else {
+		n = t.min(t.root)
+	}
+	t.delete(n)
+	if t.onEvict != nil {
+		t.onEvict(n.key, n.value)
+	}
+}
+
+// GetOrPutAll puts each pair whose key is absent, and never overwrites an existing value.
+// res[i] is the key of pairs[i] with the existing value, or the put one if the key was absent.
+// On a capped tree, a put key-value may be evicted by the cap before the call returns, res still has the put value.
+// Pair.First: Key, Pair.Second: Value
+// O(MlogN), M = len(pairs)
+func (t *rbTree) GetOrPutAll(pairs []pair.Pair) []pair.Pair {
+	res := make([]pair.Pair, len(pairs))
+	for i, p := range pairs {
+		n := t.search(p.First)
+		if n == t.nil {
+			n = t.put(p.First, p.Second)
+		}
+		res[i] = pair.Pair{First: p.First, Second: n.value}
+	}
+	return res
+}
+
+// GetWithVersion returns the value and version to key, or ok is false if not found.
+// The version of a key is bumped by every Put of it, versions are increasing in the whole tree.
+// The keys stored by a bulk build, such as NewFromSortedDesc or ReplaceAll, get new versions in ASC.
+// O(logN)
+func (t *rbTree) GetWithVersion(key interface{}) (value interface{}, version uint64, ok bool) {
+	p := t.search(key)
+	if p == t.nil {
+		return nil, 0, false
+	}
+	return p.value, p.version, true
+}
+
+// PutIfVersion stores the key-value only if the current version of key is expected, it returns whether it stored.
+// An absent key has version 0, so PutIfVersion(key, value, 0) inserts key only if it is absent.
+// O(logN)
+func (t *rbTree) PutIfVersion(key interface{}, value interface{}, expected uint64) bool {
+	p := t.search(key)
+	if p == t.nil {
+		if expected != 0 {
+			return false
+		}
+		t.Put(key, value)
+		return true
+	}
+
+	if p.version != expected {
+		return false
+	}
+	p.value = value
+	t.touch(p)
+	return true
+}
+
+// PutIfEqual stores newValue only if the current value of key equals expectedOld by eq, it returns whether it stored.
+// If key is absent, newValue is inserted only if expectedOld is nil, eq is not called then.
+// O(logN)
+func (t *rbTree) PutIfEqual(key, newValue, expectedOld interface{}, eq func(a, b interface{}) bool) bool {
+	p := t.search(key)
+	if p == t.nil {
+		if expectedOld != nil {
+			return false
+		}
+		t.Put(key, newValue)
+		return true
+	}
+
+	if !eq(p.value, expectedOld) {
+		return false
+	}
+	p.value = newValue
+	t.touch(p)
+	return true
+}
+
+// ChangedSince returns the key-values in ASC which were Put after version, their versions are greater than version.
+// Pass the greatest version seen by GetWithVersion or a previous ChangedSince to get the increment.
+// Deleted keys are not reported.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) ChangedSince(version uint64) []pair.Pair {
+	var res []pair.Pair
+	t.walkAsc(t.root, func(n *node) bool {
+		if n.version > version {
+			res = append(res, pair.Pair{First: n.key, Second: n.value})
+		}
+		return true
+	})
+	return res
 }
 
 // O(logN)
@@ -91,114 +454,862 @@ func (t *rbTree) Delete(key interface{}) {
 	t.delete(z)
 }
 
-// Min returns the key-value to the minimum key, or nil if the tree is empty.
+// RetainIf deletes the key-values which don't satisfy pred, and returns the count of deleted key-values.
+// The nodes to delete are collected by a walk first, so that pred never sees a tree being modified.
+// O(N + KlogN), K is the count of deleted key-values
+func (t *rbTree) RetainIf(pred func(key, value interface{}) bool) int {
+	var drop []*node
+	t.walkAsc(t.root, func(n *node) bool {
+		if !pred(n.key, n.value) {
+			drop = append(drop, n)
+		}
+		return true
+	})
+	// delete moves nodes instead of copying keys, so the collected nodes are still valid.
+	for _, n := range drop {
+		t.delete(n)
+	}
+	return len(drop)
+}
+
+// DeleteAt deletes key and returns the key-value next to it in ASC, so that a scan can continue without searching again.
+// ok is false if key is not found or key was the maximum.
+// For example: for k, _, ok := t.MinOk(); ok; k, _, ok = t.DeleteAt(k) {}
+// O(logN)
+func (t *rbTree) DeleteAt(key interface{}) (nextKey, nextValue interface{}, ok bool) {
+	z := t.search(key)
+	if z == t.nil {
+		return nil, nil, false
+	}
+
+	// delete() moves the successor node rather than copying its key-value, so next is still valid after it.
+	next := t.successor(z)
+	t.delete(z)
+	if next == t.nil {
+		return nil, nil, false
+	}
+	return next.key, next.value, true
+}
+
+// Neighbors returns the key-values before and after key in ASC, key must be in the tree.
+// hasPrev is false if key is the minimum or not found, hasNext is false if key is the maximum or not found.
+// Unlike searching the nearest keys of an arbitrary key, it starts from the node of key.
+// O(logN)
+func (t *rbTree) Neighbors(key interface{}) (prevKey, prevValue, nextKey, nextValue interface{}, hasPrev, hasNext bool) {
+	p := t.search(key)
+	if p == t.nil {
+		return
+	}
+
+	if prev := t.predecessor(p); prev != t.nil {
+		prevKey, prevValue, hasPrev = prev.key, prev.value, true
+	}
+	if next := t.successor(p); next != t.nil {
+		nextKey, nextValue, hasNext = next.key, next.value, true
+	}
+	return
+}
+
+// Min returns the key-value to the minimum key, or the sentinel key (nil by default, see NewWithSentinel) if the tree is empty.
 // For example: if key, value := t.Min(key); key != nil { found }
 // O(logN)
 func (t *rbTree) Min() (key, value interface{}) {
 	p := t.min(t.root)
 	if p == t.nil {
-		return nil, nil
+		return t.sentinel, nil
 	} else {
 		return p.key, p.value
 	}
 }
 
-// Max returns the key-value to the maximum key, or nil if the tree is empty.
+// Max returns the key-value to the maximum key, or the sentinel key (nil by default, see NewWithSentinel) if the tree is empty.
 // For example: if key, value := t.Max(); key != nil { found }
 // O(logN)
 func (t *rbTree) Max() (key, value interface{}) {
 	p := t.max(t.root)
 	if p == t.nil {
-		return nil, nil
+		return t.sentinel, nil
 	} else {
 		return p.key, p.value
 	}
 }
 
-// Keys traversals in ASC
+// MinOk returns the key-value to the minimum key, ok is false if the tree is empty.
+// Unlike Min, it can tell an empty tree from a minimum key which is nil.
+// O(logN)
+func (t *rbTree) MinOk() (key, value interface{}, ok bool) {
+	p := t.min(t.root)
+	if p == t.nil {
+		return nil, nil, false
+	}
+	return p.key, p.value, true
+}
+
+// MaxOk returns the key-value to the maximum key, ok is false if the tree is empty.
+// Unlike Max, it can tell an empty tree from a maximum key which is nil.
+// O(logN)
+func (t *rbTree) MaxOk() (key, value interface{}, ok bool) {
+	p := t.max(t.root)
+	if p == t.nil {
+		return nil, nil, false
+	}
+	return p.key, p.value, true
+}
+
+// MinValue returns the key-value whose value is the minimum by valCmp, ok is false if the tree is empty.
+// If some values are the same minimum, the one with the minimum key is returned.
+// O(N)
+func (t *rbTree) MinValue(valCmp func(a, b interface{}) int) (key, value interface{}, ok bool) {
+	return t.extremeValue(func(a, b interface{}) bool { return valCmp(a, b) < 0 })
+}
+
+// MaxValue returns the key-value whose value is the maximum by valCmp, ok is false if the tree is empty.
+// If some values are the same maximum, the one with the minimum key is returned.
+// O(N)
+func (t *rbTree) MaxValue(valCmp func(a, b interface{}) int) (key, value interface{}, ok bool) {
+	return t.extremeValue(func(a, b interface{}) bool { return valCmp(a, b) > 0 })
+}
+
+// Keys traversals in ASC
+// O(N)
+func (t *rbTree) Keys() []interface{} {
+	pos := 0
+	res := make([]interface{}, t.len)
+	t.rangeKeysAsc(t.root, res, &pos)
+	return res
+}
+
+// KeysFunc calls fn for each key in ASC until fn returns false, it doesn't allocate a slice like Keys.
+// O(N)
+func (t *rbTree) KeysFunc(fn func(key interface{}) bool) {
+	t.walkAsc(t.root, func(n *node) bool {
+		return fn(n.key)
+	})
+}
+
+// KeysCopyInto copies the first len(dst) keys in ASC into dst, and returns the count of copied keys,
+// which is min(len(dst), Len()). It doesn't allocate.
+// O(len(dst) + logN)
+func (t *rbTree) KeysCopyInto(dst []interface{}) (n int) {
+	t.walkAsc(t.root, func(p *node) bool {
+		if n >= len(dst) {
+			return false
+		}
+		dst[n] = p.key
+		n++
+		return true
+	})
+	return n
+}
+
+// Values traversals in ASC
+// O(N)
+func (t *rbTree) Values() []interface{} {
+	pos := 0
+	res := make([]interface{}, t.len)
+	t.rangeValuesAsc(t.root, res, &pos)
+	return res
+}
+
+// KeysOf returns the keys in ASC whose values equal value by eq, it's a reverse lookup.
+// O(N)
+func (t *rbTree) KeysOf(value interface{}, eq func(a, b interface{}) bool) []interface{} {
+	var res []interface{}
+	t.walkAsc(t.root, func(n *node) bool {
+		if eq(n.value, value) {
+			res = append(res, n.key)
+		}
+		return true
+	})
+	return res
+}
+
+// RangeAll traversals in ASC
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) RangeAll() []pair.Pair {
+	pos := 0
+	res := make([]pair.Pair, t.len)
+	t.rangeAllAsc(t.root, res, &pos)
+	return res
+}
+
+// RangeAllDesc traversals in DESC
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) RangeAllDesc() []pair.Pair {
+	pos := 0
+	res := make([]pair.Pair, t.len)
+	t.rangeAllDesc(t.root, res, &pos)
+	return res
+}
+
+// Shuffle returns all key-values in a random order by Fisher-Yates shuffle of rng, it's for sampling.
+// If rng is nil, the default source of math/rand is used.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) Shuffle(rng *rand.Rand) []pair.Pair {
+	res := t.RangeAll()
+	swap := func(i, j int) {
+		res[i], res[j] = res[j], res[i]
+	}
+	if rng != nil {
+		rng.Shuffle(len(res), swap)
+	} else {
+		rand.Shuffle(len(res), swap)
+	}
+	return res
+}
+
+// Rebuild rebalances the tree in place to the minimal height for its size, like NewFromSortedDesc,
+// it's useful after heavy deletions. The nodes are relinked instead of copied, so Handles and Cursors stay valid.
+// It is not named Compact as requested, since Compact is the one-line printer.
+// O(N)
+func (t *rbTree) Rebuild() {
+	nodes := make([]*node, 0, t.len)
+	t.walkAsc(t.root, func(n *node) bool {
+		nodes = append(nodes, n)
+		return true
+	})
+	t.root = t.relink(nodes, 0, len(nodes), 1, bits.Len(uint(len(nodes)+1)), t.nil)
+}
+
+// Entries traversals in ASC, it is the same as RangeAll but returns Entry.
+// O(N)
+func (t *rbTree) Entries() []Entry {
+	res := make([]Entry, 0, t.len)
+	t.walkAsc(t.root, func(n *node) bool {
+		res = append(res, Entry{Key: n.key, Value: n.value})
+		return true
+	})
+	return res
+}
+
+// EntriesDesc traversals in DESC, it is the same as RangeAllDesc but returns Entry.
+// O(N)
+func (t *rbTree) EntriesDesc() []Entry {
+	res := make([]Entry, 0, t.len)
+	t.walkDesc(t.root, func(n *node) bool {
+		res = append(res, Entry{Key: n.key, Value: n.value})
+		return true
+	})
+	return res
+}
+
+// LevelOrder traversals level by level, res[0] is the root, res[i] are the key-values of depth i in ASC.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) LevelOrder() [][]pair.Pair {
+	var res [][]pair.Pair
+	level := []*node{t.root}
+	if t.root == t.nil {
+		level = nil
+	}
+	for len(level) > 0 {
+		var next []*node
+		row := make([]pair.Pair, len(level))
+		for i, n := range level {
+			row[i] = pair.Pair{First: n.key, Second: n.value}
+			if n.left != t.nil {
+				next = append(next, n.left)
+			}
+			if n.right != t.nil {
+				next = append(next, n.right)
+			}
+		}
+		res = append(res, row)
+		level = next
+	}
+	return res
+}
+
+// PreOrder traversals in pre-order: the root, the left subtree, then the right subtree.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) PreOrder() []pair.Pair {
+	res, _ := t.PreOrderColors()
+	return res
+}
+
+// PreOrderColors is PreOrder which also returns whether each node is red, isRed[i] is the color of res[i].
+// The result can be passed to NewFromPreOrder to rebuild the identical tree.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) PreOrderColors() (res []pair.Pair, isRed []bool) {
+	res, isRed = make([]pair.Pair, 0, t.len), make([]bool, 0, t.len)
+	t.walkPre(t.root, func(n *node) {
+		res = append(res, pair.Pair{First: n.key, Second: n.value})
+		isRed = append(isRed, n.color == red)
+	})
+	return res, isRed
+}
+
+// PostOrder traversals in post-order: the left subtree, the right subtree, then the root.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) PostOrder() []pair.Pair {
+	res := make([]pair.Pair, 0, t.len)
+	t.walkPost(t.root, func(n *node) {
+		res = append(res, pair.Pair{First: n.key, Second: n.value})
+	})
+	return res
+}
+
+// EntriesBatched streams all key-values in ASC by batches of batchSize, only the last batch may be smaller.
+// The channel is closed after the last batch, the tree must not be modified until then.
+// The producer can't stop before the end, use EntriesBatchedCtx if the consumer may quit early.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) EntriesBatched(batchSize int) <-chan []pair.Pair {
+	return t.EntriesBatchedCtx(context.Background(), batchSize)
+}
+
+// EntriesBatchedCtx is EntriesBatched whose producer stops and closes the channel when ctx is done.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) EntriesBatchedCtx(ctx context.Context, batchSize int) <-chan []pair.Pair {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	ch := make(chan []pair.Pair)
+	go func() {
+		defer close(ch)
+		batch := make([]pair.Pair, 0, batchSize)
+		send := func() bool {
+			select {
+			case ch <- batch:
+				batch = make([]pair.Pair, 0, batchSize)
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		ok := t.walkAsc(t.root, func(n *node) bool {
+			batch = append(batch, pair.Pair{First: n.key, Second: n.value})
+			return len(batch) < batchSize || send()
+		})
+		if ok && len(batch) > 0 {
+			send()
+		}
+	}()
+	return ch
+}
+
+// ToMap returns all key-values in a map, the order is lost. It returns an empty map for an empty tree.
+// Keys must be comparable to be map keys.
+// O(N)
+func (t *rbTree) ToMap() map[interface{}]interface{} {
+	res := make(map[interface{}]interface{}, t.len)
+	t.walkAsc(t.root, func(n *node) bool {
+		res[n.key] = n.value
+		return true
+	})
+	return res
+}
+
+// Range traversals in [minKey, maxKey] in ASC
+// MinKey & MaxKey are all closed interval.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) Range(minKey, maxKey interface{}) []pair.Pair {
+	return t.rangeAsc(t.root, nil, minKey, maxKey, t.cmp)
+}
+
+// RangeMap returns project(key, value) of the key-values in [minKey, maxKey] in ASC, the same as mapping Range.
+// O(N)
+func (t *rbTree) RangeMap(minKey, maxKey interface{}, project func(key, value interface{}) interface{}) []interface{} {
+	var res []interface{}
+	t.walkRange(t.root, minKey, maxKey, func(n *node) {
+		res = append(res, project(n.key, n.value))
+	})
+	return res
+}
+
+// RangeCtx is Range which can be canceled by ctx, ctx is checked every ctxCheckInterval visited nodes.
+// If ctx is done, it returns the key-values found so far and ctx.Err().
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) RangeCtx(ctx context.Context, minKey, maxKey interface{}) ([]pair.Pair, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	visited := 0
+	return t.rangeAscCtx(ctx, t.root, nil, minKey, maxKey, &visited)
+}
+
+// RangeMulti traversals in several intervals in ASC with one walk, each interval is [min, max], closed interval.
+// The intervals must be sorted in ASC and not overlap, that is intervals[i][1] < intervals[i+1][0], otherwise it panics.
+// The result is the same as concatenating Range of each interval.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) RangeMulti(intervals [][2]interface{}) []pair.Pair {
+	for i := range intervals {
+		if t.cmp(intervals[i][0], intervals[i][1]) > 0 {
+			panic(fmt.Sprintf("rbtree: RangeMulti interval %d has min > max", i))
+		}
+		if i > 0 && t.cmp(intervals[i-1][1], intervals[i][0]) >= 0 {
+			panic(fmt.Sprintf("rbtree: RangeMulti intervals %d and %d are unsorted or overlapped", i-1, i))
+		}
+	}
+	pos := 0
+	return t.rangeMulti(t.root, nil, intervals, &pos)
+}
+
+// Window returns up to before key-values less than center and up to after key-values greater than or equal to center,
+// all in ASC. The window is split at the ceiling of center, which needn't be in the tree.
+// Pair.First: Key, Pair.Second: Value
+// O(logN + before + after)
+func (t *rbTree) Window(center interface{}, before, after int) []pair.Pair {
+	c := t.ceiling(center)
+	var res []pair.Pair
+	p := t.max(t.root)
+	if c != t.nil {
+		p = t.predecessor(c)
+	}
+	for ; p != t.nil && len(res) < before; p = t.predecessor(p) {
+		res = append(res, pair.Pair{First: p.key, Second: p.value})
+	}
+	for l, r := 0, len(res)-1; l < r; l, r = l+1, r-1 {
+		res[l], res[r] = res[r], res[l]
+	}
+	for n := c; n != t.nil && after > 0; n = t.successor(n) {
+		res = append(res, pair.Pair{First: n.key, Second: n.value})
+		after--
+	}
+	return res
+}
+
+// Clamp returns the key-value to the minimum key >= key, or the maximum key if key is greater than all keys,
+// so a key below the range is clamped to the minimum key. ok is false if the tree is empty.
+// O(logN)
+func (t *rbTree) Clamp(key interface{}) (k, v interface{}, ok bool) {
+	p := t.ceiling(key)
+	if p == t.nil {
+		p = t.max(t.root)
+	}
+	if p == t.nil {
+		return nil, nil, false
+	}
+	return p.key, p.value, true
+}
+
+// ClampFloor is like Clamp but returns the maximum key <= key, or the minimum key if key is less than all keys.
+// O(logN)
+func (t *rbTree) ClampFloor(key interface{}) (k, v interface{}, ok bool) {
+	p := t.floor(key)
+	if p == t.nil {
+		p = t.min(t.root)
+	}
+	if p == t.nil {
+		return nil, nil, false
+	}
+	return p.key, p.value, true
+}
+
+// Interpolate returns the value at x by linear interpolation between the values of the keys around x,
+// such as a lookup table. keyAsFloat and valAsFloat convert a key and a value to numbers, keyAsFloat must keep
+// the order of cmp. If x is a key, its value is returned. ok is false if x is out of [Min, Max].
+// O(logN)
+func (t *rbTree) Interpolate(x float64, keyAsFloat, valAsFloat func(interface{}) float64) (y float64, ok bool) {
+	lo, hi := t.bracket(x, keyAsFloat)
+	if lo == t.nil || hi == t.nil {
+		return 0, false
+	}
+	return interpolate(x, lo, hi, keyAsFloat, valAsFloat), true
+}
+
+// InterpolateClamped is like Interpolate, but returns the value of Min or Max if x is out of [Min, Max].
+// ok is false only if the tree is empty.
+// O(logN)
+func (t *rbTree) InterpolateClamped(x float64, keyAsFloat, valAsFloat func(interface{}) float64) (y float64, ok bool) {
+	lo, hi := t.bracket(x, keyAsFloat)
+	if lo == t.nil {
+		lo = hi
+	} else if hi == t.nil {
+		hi = lo
+	}
+	if lo == t.nil {
+		return 0, false
+	}
+	return interpolate(x, lo, hi, keyAsFloat, valAsFloat), true
+}
+
+// SelectRange returns the key-values at the indexes [i, j) in ASC, the indexes start from 0 and are clamped to [0, Len()].
+// Pair.First: Key, Pair.Second: Value
+// O(logN + M), M = j - i
+func (t *rbTree) SelectRange(i, j int) []pair.Pair {
+	if i < 0 {
+		i = 0
+	}
+	if j > t.len {
+		j = t.len
+	}
+	if i >= j {
+		return []pair.Pair{}
+	}
+
+	res := make([]pair.Pair, 0, j-i)
+	for n := t.at(i); len(res) < j-i; n = t.successor(n) {
+		res = append(res, pair.Pair{First: n.key, Second: n.value})
+	}
+	return res
+}
+
+// RangeN get num key-values which >= key in ASC
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) RangeN(num int, key interface{}) []pair.Pair {
+	return t.rangeAscN(t.root, nil, num, key, t.cmp)
+}
+
+// RangeDescN get num key-values which <= key in DESC
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) RangeDescN(num int, key interface{}) []pair.Pair {
+	return t.rangeDescN(t.root, nil, num, key, t.cmp)
+}
+
+// RangeDesc traversals in [minKey, maxKey] in DESC
+// MinKey & MaxKey are all closed interval.
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (t *rbTree) RangeDesc(minKey, maxKey interface{}) []pair.Pair {
+	return t.rangeDesc(t.root, nil, minKey, maxKey, t.cmp)
+}
+
+// PopMin will delete the min node and return it, it returns the sentinel key like Min if the tree is empty.
+// O(logN)
+func (t *rbTree) PopMin() (key, value interface{}) {
+	p := t.min(t.root)
+	if p == t.nil {
+		return t.sentinel, nil
+	}
+	t.delete(p)
+	return p.key, p.value
+}
+
+// PopMax will delete the max node and return it, it returns the sentinel key like Max if the tree is empty.
+// O(logN)
+func (t *rbTree) PopMax() (key, value interface{}) {
+	p := t.max(t.root)
+	if p == t.nil {
+		return t.sentinel, nil
+	}
+	t.delete(p)
+	return p.key, p.value
+}
+
+// DefaultCompactLimit is the count of key-values printed by Compact before it truncates.
+const DefaultCompactLimit = 64
+
+// Compact returns a single-line representation in ASC, such as {1:a, 2:b, 3:c}.
+// It prints at most DefaultCompactLimit key-values, see CompactN.
+// O(N)
+func (t *rbTree) Compact() string {
+	return t.CompactN(DefaultCompactLimit)
+}
+
+// CompactN returns a single-line representation in ASC with at most limit key-values,
+// the rest are summarized, such as {1:a, 2:b, ...(+98)}. If limit <= 0, all key-values are printed.
+// O(N)
+func (t *rbTree) CompactN(limit int) string {
+	var b strings.Builder
+	b.WriteString("{")
+	count := 0
+	t.walkAsc(t.root, func(n *node) bool {
+		if limit > 0 && count >= limit {
+			return false
+		}
+		if count > 0 {
+			b.WriteString(", ")
+		}
+		_, _ = fmt.Fprintf(&b, "%v:%v", n.key, n.value)
+		count++
+		return true
+	})
+	if count < t.len {
+		_, _ = fmt.Fprintf(&b, ", ...(+%d)", t.len-count)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// Intersect returns a new rbTree with the keys in both t and other, values are taken from t.
+// t and other must have the same cmp.
+// O(N+M)
+func (t *rbTree) Intersect(other *rbTree) *rbTree {
+	var res []pair.Pair
+	t.merge(other, func(a, b *node) {
+		if a != nil && b != nil {
+			res = append(res, pair.Pair{First: a.key, Second: a.value})
+		}
+	})
+	return newFromSortedPairs(t.cmp, res)
+}
+
+// Union returns a new rbTree with the keys in t or other, if a key is in both, the value is taken from t.
+// t and other must have the same cmp.
+// O(N+M)
+func (t *rbTree) Union(other *rbTree) *rbTree {
+	res := make([]pair.Pair, 0, t.len+other.len)
+	t.merge(other, func(a, b *node) {
+		if a != nil {
+			res = append(res, pair.Pair{First: a.key, Second: a.value})
+		} else {
+			res = append(res, pair.Pair{First: b.key, Second: b.value})
+		}
+	})
+	return newFromSortedPairs(t.cmp, res)
+}
+
+// Difference returns a new rbTree with the keys in t but not in other.
+// t and other must have the same cmp.
+// O(N+M)
+func (t *rbTree) Difference(other *rbTree) *rbTree {
+	var res []pair.Pair
+	t.merge(other, func(a, b *node) {
+		if a != nil && b == nil {
+			res = append(res, pair.Pair{First: a.key, Second: a.value})
+		}
+	})
+	return newFromSortedPairs(t.cmp, res)
+}
+
+// Diff returns the changes from t to other: added are the key-values only in other, removed are the key-values only in t,
+// changed are the key-values of other whose keys are in both but values differ by valEq. All of them are in ASC.
+// t and other must have the same cmp.
+// Pair.First: Key, Pair.Second: Value
+// O(N+M)
+func (t *rbTree) Diff(other *rbTree, valEq func(a, b interface{}) bool) (added, removed, changed []pair.Pair) {
+	t.merge(other, func(a, b *node) {
+		if a == nil {
+			added = append(added, pair.Pair{First: b.key, Second: b.value})
+		} else if b == nil {
+			removed = append(removed, pair.Pair{First: a.key, Second: a.value})
+		} else if !valEq(a.value, b.value) {
+			changed = append(changed, pair.Pair{First: b.key, Second: b.value})
+		}
+	})
+	return added, removed, changed
+}
+
+// CompatibleCmp is a heuristic check that t and other order keys the same way, to guard Merge, Intersect, Diff and
+// the other operations which require the same cmp. It takes at most samples keys evenly from each tree,
+// and returns false if the two cmps disagree on the order of any two of them. true doesn't prove the cmps are the same.
+// Both cmps must accept the keys of both trees.
+// O(SlogS + SlogN), S = samples
+func (t *rbTree) CompatibleCmp(other *rbTree, samples int) bool {
+	keys := append(t.sampleKeys(samples), other.sampleKeys(samples)...)
+	sort.Slice(keys, func(i, j int) bool {
+		return t.cmp(keys[i], keys[j]) < 0
+	})
+	// Both cmps are total orders, so they agree on all pairs if they agree on the neighbours in the order of t.cmp.
+	for i := 1; i < len(keys); i++ {
+		c1, c2 := t.cmp(keys[i-1], keys[i]), other.cmp(keys[i-1], keys[i])
+		if (c1 == 0) != (c2 == 0) || (c1 < 0) != (c2 < 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// sampleKeys returns at most k keys of t at even intervals in ASC.
+// O(klogN)
+func (t *rbTree) sampleKeys(k int) []interface{} {
+	if k > t.len {
+		k = t.len
+	}
+	var res []interface{}
+	for i := 0; i < k; i++ {
+		res = append(res, t.at(i*t.len/k).key)
+	}
+	return res
+}
+
+// ApplyDelta sets the value of each key of delta in t to merge(base, deltaValue), base is the value in t,
+// or nil if the key is new to t. t and delta must have the same cmp.
+// The existing keys are updated by a walk of both trees, then the new keys are inserted.
+// O(N+M+KlogN), K is the count of new keys
+func (t *rbTree) ApplyDelta(delta *rbTree, merge func(base, delta interface{}) interface{}) {
+	var added []pair.Pair
+	t.merge(delta, func(a, b *node) {
+		if b == nil {
+			return
+		} else if a == nil {
+			added = append(added, pair.Pair{First: b.key, Second: merge(nil, b.value)})
+		} else {
+			a.value = merge(a.value, b.value)
+			t.touch(a)
+		}
+	})
+	for _, p := range added {
+		t.put(p.First, p.Second)
+	}
+}
+
+// IsOrdered returns whether the keys in ASC of t are strictly increasing by cmp, cmp may be different from the cmp of t.
+// It can tell whether a tree can be used with another cmp, or whether Recompare is needed.
 // O(N)
-func (t *rbTree) Keys() []interface{} {
-	pos := 0
-	res := make([]interface{}, t.len)
-	t.rangeKeysAsc(t.root, res, &pos)
-	return res
+func (t *rbTree) IsOrdered(cmp CmpFunc) bool {
+	var prev *node
+	return t.walkAsc(t.root, func(n *node) bool {
+		if prev != nil && cmp(prev.key, n.key) >= 0 {
+			return false
+		}
+		prev = n
+		return true
+	})
 }
 
-// Values traversals in ASC
-// O(N)
-func (t *rbTree) Values() []interface{} {
-	pos := 0
-	res := make([]interface{}, t.len)
-	t.rangeValuesAsc(t.root, res, &pos)
-	return res
+// Recompare returns a new rbTree with the key-values of t ordered by newCmp.
+// If some keys are the same under newCmp, only the first one in the order of t is kept.
+// It panics if the sorted keys are not strictly increasing by newCmp, which means newCmp is inconsistent.
+// O(NlogN)
+func (t *rbTree) Recompare(newCmp CmpFunc) *rbTree {
+	pairs := t.RangeAll()
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return newCmp(pairs[i].First, pairs[j].First) < 0
+	})
+	res := pairs[:0]
+	for i := range pairs {
+		if i > 0 && newCmp(pairs[i].First, res[len(res)-1].First) == 0 {
+			continue
+		}
+		res = append(res, pairs[i])
+	}
+	for i := 1; i < len(res); i++ {
+		if newCmp(res[i-1].First, res[i].First) >= 0 {
+			panic("rbtree: Recompare got keys not strictly increasing by newCmp, newCmp may be inconsistent")
+		}
+	}
+	return newFromSortedPairs(newCmp, res)
 }
 
-// RangeAll traversals in ASC
-// Pair.First: Key, Pair.Second: Value
+// ContentHash folds h(key, value) of each key-value in ASC into a 64-bit digest.
+// Trees with the same key-values have the same hash, so that they can be compared without serializing.
+// The fold is order-sensitive, h is supposed to be a good hash of both key and value.
 // O(N)
-func (t *rbTree) RangeAll() []pair.Pair {
-	pos := 0
-	res := make([]pair.Pair, t.len)
-	t.rangeAllAsc(t.root, res, &pos)
+func (t *rbTree) ContentHash(h func(key, value interface{}) uint64) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	sum := uint64(offset64)
+	t.walkAsc(t.root, func(n *node) bool {
+		sum ^= h(n.key, n.value)
+		sum *= prime64
+		return true
+	})
+	return sum
+}
+
+// CountLess returns the count of keys which are less than key, key needn't be in the tree.
+// O(logN)
+func (t *rbTree) CountLess(key interface{}) int {
+	res := 0
+	for p := t.root; p != t.nil; {
+		if t.cmp(p.key, key) < 0 {
+			res += t.count(p.left) + 1
+			p = p.right
+		} else {
+			p = p.left
+		}
+	}
 	return res
 }
 
-// RangeAllDesc traversals in DESC
-// Pair.First: Key, Pair.Second: Value
-// O(N)
-func (t *rbTree) RangeAllDesc() []pair.Pair {
-	pos := 0
-	res := make([]pair.Pair, t.len)
-	t.rangeAllDesc(t.root, res, &pos)
+// CountGreater returns the count of keys which are greater than key, key needn't be in the tree.
+// O(logN)
+func (t *rbTree) CountGreater(key interface{}) int {
+	res := 0
+	for p := t.root; p != t.nil; {
+		if t.cmp(p.key, key) > 0 {
+			res += t.count(p.right) + 1
+			p = p.left
+		} else {
+			p = p.right
+		}
+	}
 	return res
 }
 
-// Range traversals in [minKey, maxKey] in ASC
-// MinKey & MaxKey are all closed interval.
-// Pair.First: Key, Pair.Second: Value
-// O(N)
-func (t *rbTree) Range(minKey, maxKey interface{}) []pair.Pair {
-	return t.rangeAsc(t.root, nil, minKey, maxKey, t.cmp)
+// RangeCount returns the count of keys in [minKey, maxKey], the same as len(Range(minKey, maxKey)) without the walk.
+// O(logN)
+func (t *rbTree) RangeCount(minKey, maxKey interface{}) int {
+	if t.cmp(minKey, maxKey) > 0 {
+		return 0
+	}
+	return t.len - t.CountLess(minKey) - t.CountGreater(maxKey)
 }
 
-// RangeN get num key-values which >= key in ASC
-// Pair.First: Key, Pair.Second: Value
-// O(N)
-func (t *rbTree) RangeN(num int, key interface{}) []pair.Pair {
-	return t.rangeAscN(t.root, nil, num, key, t.cmp)
+// Quantile returns project(key) of the q-th quantile key by the nearest-rank method, q is in [0, 1].
+// For example, the keys are latencies, Quantile(0.99, f) returns p99. It returns NaN if the tree is empty.
+// O(logN)
+func (t *rbTree) Quantile(q float64, project func(key interface{}) float64) (value float64) {
+	if t.len == 0 {
+		return math.NaN()
+	}
+	rank := int(math.Ceil(q*float64(t.len))) - 1
+	if rank < 0 {
+		rank = 0
+	} else if rank >= t.len {
+		rank = t.len - 1
+	}
+	return project(t.at(rank).key)
 }
 
-// RangeDescN get num key-values which <= key in DESC
-// Pair.First: Key, Pair.Second: Value
-// O(N)
-func (t *rbTree) RangeDescN(num int, key interface{}) []pair.Pair {
-	return t.rangeDescN(t.root, nil, num, key, t.cmp)
+// LeftCount returns the count of nodes in the left subtree of root, for monitoring balance.
+// O(1)
+func (t *rbTree) LeftCount() int {
+	if t.root == t.nil {
+		return 0
+	}
+	return t.count(t.root.left)
 }
 
-// RangeDesc traversals in [minKey, maxKey] in DESC
-// MinKey & MaxKey are all closed interval.
-// Pair.First: Key, Pair.Second: Value
-// O(N)
-func (t *rbTree) RangeDesc(minKey, maxKey interface{}) []pair.Pair {
-	return t.rangeDesc(t.root, nil, minKey, maxKey, t.cmp)
+// RightCount returns the count of nodes in the right subtree of root, for monitoring balance.
+// O(1)
+func (t *rbTree) RightCount() int {
+	if t.root == t.nil {
+		return 0
+	}
+	return t.count(t.root.right)
 }
 
-// PopMin will delete the min node and return it.
-// O(logN)
-func (t *rbTree) PopMin() (key, value interface{}) {
-	p := t.min(t.root)
-	t.delete(p)
-	return p.key, p.value
+// Metrics are the counts of the rebalancing work done by Put and Delete since the tree was created or ResetMetrics.
+type Metrics struct {
+	LeftRotations  int
+	RightRotations int
+	InsertFixups   int // iterations of the fixup loop after insertions
+	DeleteFixups   int // iterations of the fixup loop after deletions
 }
 
-// PopMax will delete the max node and return it.
-// O(logN)
-func (t *rbTree) PopMax() (key, value interface{}) {
-	p := t.max(t.root)
-	t.delete(p)
-	return p.key, p.value
+// Metrics returns the counts of rotations and fixup iterations, it helps to compare insertion orders.
+// O(1)
+func (t *rbTree) Metrics() Metrics {
+	return t.metrics
+}
+
+// ResetMetrics sets all counts of Metrics to 0.
+// O(1)
+func (t *rbTree) ResetMetrics() {
+	t.metrics = Metrics{}
+}
+
+// Trim releases memory retained after deletions.
+// rbTree has no node pool and deleted nodes are left to the GC, so Trim is effectively a no-op: it only clears
+// the links to a deleted node which the sentinel nil node may keep after delete fixups, that is at most one node.
+// O(1)
+func (t *rbTree) Trim() {
+	t.nil.parent, t.nil.left, t.nil.right = nil, nil, nil
 }
 
+// Reserve is a hint that about n Puts of new keys are coming.
+// rbTree has no node pool, every new key allocates its own node, so Reserve does nothing for now,
+// and the Puts after it allocate as many nodes as without it.
+// O(1)
+func (t *rbTree) Reserve(n int) {}
+
 // String is very useful when debugging
 // Example: fmt.Println(t) will print as follows:
 /*
@@ -211,6 +1322,42 @@ func (t *rbTree) PopMax() (key, value interface{}) {
 func (t *rbTree) String() string {
 	step := t.getKeyMaxLen()
 	step += 3 // one step [key]colour, example: [123456]B
+	return t.print(step, func(n *node) string {
+		return fmt.Sprint(n.key)
+	})
+}
+
+// Pretty prints the tree like String, each node is printed as [key:value]colour.
+// formatKey and formatValue render keys and values, fmt.Sprint is used if they are nil,
+// nil keys and values are printed as <nil> without calling them.
+// O(N)
+func (t *rbTree) Pretty(formatKey, formatValue func(interface{}) string) string {
+	format := func(f func(interface{}) string, v interface{}) string {
+		if v == nil {
+			return "<nil>"
+		} else if f == nil {
+			return fmt.Sprint(v)
+		}
+		return f(v)
+	}
+	labels := make(map[*node]string, t.len)
+	step := uint(0)
+	t.walkAsc(t.root, func(n *node) bool {
+		label := format(formatKey, n.key) + ":" + format(formatValue, n.value)
+		labels[n] = label
+		if uint(len(label)) > step {
+			step = uint(len(label))
+		}
+		return true
+	})
+	step += 3 // one step [key:value]colour
+	return t.print(step, func(n *node) string {
+		return labels[n]
+	})
+}
+
+// print prints the tree in the layout of String, label returns the text of a node, step is the width of a node.
+func (t *rbTree) print(step uint, label func(n *node) string) string {
 	depth := t.getDepth(t.root)
 	lDepth := t.getLeftDepth(t.root)
 	lMove := uint(0)
@@ -221,7 +1368,7 @@ func (t *rbTree) String() string {
 	for i := uint(0); i < depth; i++ {
 		buffs[i] = &strings.Builder{}
 	}
-	t.makeString(t.root, buffs, step, lMove, depth, 1, true, false)
+	t.makeString(t.root, buffs, step, lMove, depth, 1, true, false, label)
 	/*check, ok := t.root.check()
 	strCheck := fmt.Sprintf("Tree check is %v, MaxH %v, MinH %v, BlackH %v, Len %v, Root colour: %v", ok, check.MaxH, check.MinH, check.BlackH, check.Len, check.Colour)
 	ss := []string{strCheck}*/
@@ -249,11 +1396,69 @@ func (t *rbTree) search(key interface{}) *node {
 	return p
 }
 
+// ceiling returns the node of the minimum key >= key, or t.nil if none.
+// O(logN)
+func (t *rbTree) ceiling(key interface{}) *node {
+	res := t.nil
+	for p := t.root; p != t.nil; {
+		if cmp := t.cmp(p.key, key); cmp == 0 {
+			return p
+		} else if cmp > 0 {
+			res, p = p, p.left
+		} else {
+			p = p.right
+		}
+	}
+	return res
+}
+
+// floor returns the node of the maximum key <= key, or t.nil if none.
+// O(logN)
+func (t *rbTree) floor(key interface{}) *node {
+	res := t.nil
+	for p := t.root; p != t.nil; {
+		if cmp := t.cmp(p.key, key); cmp == 0 {
+			return p
+		} else if cmp < 0 {
+			res, p = p, p.right
+		} else {
+			p = p.left
+		}
+	}
+	return res
+}
+
+// bracket returns the nodes of the maximum key <= x and the minimum key >= x by keyAsFloat, t.nil if none.
+// O(logN)
+func (t *rbTree) bracket(x float64, keyAsFloat func(interface{}) float64) (lo, hi *node) {
+	lo, hi = t.nil, t.nil
+	for p := t.root; p != t.nil; {
+		if k := keyAsFloat(p.key); k == x {
+			return p, p
+		} else if k < x {
+			lo, p = p, p.right
+		} else {
+			hi, p = p, p.left
+		}
+	}
+	return lo, hi
+}
+
 // O(logN)
 func (t *rbTree) delete(z *node) {
 	if z == t.nil {
 		return
 	}
+	// The node taken out of its position is z, or the successor of z which replaces z.
+	// Each node above that position loses one node in its subtree.
+	removed := z
+	if z.left != t.nil && z.right != t.nil {
+		removed = t.min(z.right)
+	}
+	for p := removed.parent; p != t.nil; p = p.parent {
+		p.size--
+	}
+
 	y := z
 	yOriginalColor := y.color
 	var x *node
@@ -281,16 +1486,21 @@ func (t *rbTree) delete(z *node) {
 		y.left = z.left
 		y.left.parent = y
 		y.color = z.color
+		y.size = z.size
 	}
 	t.len--
 
 	if yOriginalColor == black {
 		t.fixupDelete(x)
 	}
+	if t.handles {
+		z.parent = nil // z is out of the tree, mark it for Handle.
+	}
 }
 
 // O(1)
 func (t *rbTree) leftRotate(x *node) {
+	t.metrics.LeftRotations++
 	y := x.right
 
 	x.right = y.left
@@ -309,10 +1519,14 @@ func (t *rbTree) leftRotate(x *node) {
 
 	y.left = x
 	x.parent = y
+
+	y.size = x.size
+	x.size = x.left.size + x.right.size + 1
 }
 
 // O(1)
 func (t *rbTree) rightRotate(x *node) {
+	t.metrics.RightRotations++
 	y := x.left
 
 	x.left = y.right
@@ -331,6 +1545,9 @@ func (t *rbTree) rightRotate(x *node) {
 
 	y.right = x
 	x.parent = y
+
+	y.size = x.size
+	x.size = x.left.size + x.right.size + 1
 }
 
 func (t *rbTree) transplant(u, v *node) {
@@ -352,6 +1569,7 @@ func (t *rbTree) fixupInsert(z *node) {
 	// 2. if t.root == z.parent, z.parent must be black
 	// 3. z != t.root and z.parent must be red
 	for z.parent.color == red { // if z == t.root, z.parent must be nilNode, and nilNode.color == black
+		t.metrics.InsertFixups++
 		if z.parent == z.parent.parent.left {
 			y := z.parent.parent.right // uncle node
 			if y.color == red {        // case 1
@@ -401,6 +1619,7 @@ func (t *rbTree) fixupDelete(x *node) {
 	//   2. if x.color == red, we can change x to black.
 	// In other cases, we fixup in loop.
 	for x != t.root && x.color == black {
+		t.metrics.DeleteFixups++
 		if x == x.parent.left {
 			w := x.parent.right
 			if w.color == red {
@@ -456,6 +1675,117 @@ func (t *rbTree) fixupDelete(x *node) {
 	x.color = black
 }
 
+// newFromSorted builds a balanced rbTree of n key-values, at(i) returns the i-th key-value in ASC.
+// O(N)
+func newFromSorted(f CmpFunc, n int, at func(i int) (key, value interface{})) *rbTree {
+	t := New(f)
+	// The top floor(log2(n+1)) levels are full and black, the nodes of the last incomplete level are red,
+	// so that every path has the same count of black nodes.
+	redDepth := bits.Len(uint(n + 1))
+	t.root = t.buildSorted(at, 0, n, 1, redDepth, t.nil)
+	t.len = n
+	t.touchAll(t.root)
+	return t
+}
+
+// buildSorted builds the subtree of the key-values [lo, hi) and returns its root.
+func (t *rbTree) buildSorted(at func(i int) (key, value interface{}), lo, hi, depth, redDepth int, parent *node) *node {
+	if lo >= hi {
+		return t.nil
+	}
+
+	mid := int(uint(lo+hi) >> 1)
+	key, value := at(mid)
+	n := &node{key: key, value: value, parent: parent, color: black, size: hi - lo}
+	if depth == redDepth {
+		n.color = red
+	}
+	n.left = t.buildSorted(at, lo, mid, depth+1, redDepth, n)
+	n.right = t.buildSorted(at, mid+1, hi, depth+1, redDepth, n)
+	return n
+}
+
+// relink links nodes[lo, hi), which are in ASC, into a balanced subtree and returns its root, see buildSorted.
+func (t *rbTree) relink(nodes []*node, lo, hi, depth, redDepth int, parent *node) *node {
+	if lo >= hi {
+		return t.nil
+	}
+
+	mid := int(uint(lo+hi) >> 1)
+	n := nodes[mid]
+	n.parent, n.color, n.size = parent, black, hi-lo
+	if depth == redDepth {
+		n.color = red
+	}
+	n.left = t.relink(nodes, lo, mid, depth+1, redDepth, n)
+	n.right = t.relink(nodes, mid+1, hi, depth+1, redDepth, n)
+	return n
+}
+
+// interpolate returns the value at x on the line through the key-values of lo and hi.
+func interpolate(x float64, lo, hi *node, keyAsFloat, valAsFloat func(interface{}) float64) float64 {
+	if lo == hi {
+		return valAsFloat(lo.value)
+	}
+	x0, x1 := keyAsFloat(lo.key), keyAsFloat(hi.key)
+	y0, y1 := valAsFloat(lo.value), valAsFloat(hi.value)
+	return y0 + (y1-y0)*(x-x0)/(x1-x0)
+}
+
+// newFromSortedPairs builds a balanced rbTree from pairs which are sorted in ASC without duplicate keys.
+// O(N)
+func newFromSortedPairs(f CmpFunc, pairs []pair.Pair) *rbTree {
+	return newFromSorted(f, len(pairs), func(i int) (key, value interface{}) {
+		return pairs[i].First, pairs[i].Second
+	})
+}
+
+// buildPreOrder builds the subtree of the pairs from *pos whose keys are in (lower, upper), nil means no bound.
+// The pairs left out of the bounds are for the ancestors, or *pos stops before len(pairs) if they are out of order.
+func (t *rbTree) buildPreOrder(pairs []pair.Pair, isRed []bool, pos *int, lower, upper *node, parent *node) *node {
+	if *pos >= len(pairs) ||
+		(lower != nil && t.cmp(pairs[*pos].First, lower.key) <= 0) ||
+		(upper != nil && t.cmp(pairs[*pos].First, upper.key) >= 0) {
+		return t.nil
+	}
+
+	n := &node{key: pairs[*pos].First, value: pairs[*pos].Second, parent: parent, color: black}
+	if isRed[*pos] {
+		n.color = red
+	}
+	*pos++
+	n.left = t.buildPreOrder(pairs, isRed, pos, lower, n, n)
+	n.right = t.buildPreOrder(pairs, isRed, pos, n, upper, n)
+	n.size = n.left.size + n.right.size + 1
+	return n
+}
+
+// merge walks t and other together in ASC, and calls fn for each key of them.
+// a is the node of t and b is the node of other, either of them is nil if the key is absent in its tree.
+// fn must not modify t or other. t and other must have the same cmp.
+// O(N+M)
+func (t *rbTree) merge(other *rbTree, fn func(a, b *node)) {
+	a, b := t.min(t.root), other.min(other.root)
+	for a != t.nil || b != other.nil {
+		if b == other.nil {
+			fn(a, nil)
+			a = t.successor(a)
+		} else if a == t.nil {
+			fn(nil, b)
+			b = other.successor(b)
+		} else if cmp := t.cmp(a.key, b.key); cmp < 0 {
+			fn(a, nil)
+			a = t.successor(a)
+		} else if cmp > 0 {
+			fn(nil, b)
+			b = other.successor(b)
+		} else {
+			fn(a, b)
+			a, b = t.successor(a), other.successor(b)
+		}
+	}
+}
+
 // O(logN)
 func (t *rbTree) getKeyMaxLen() uint {
 	minNode := t.min(t.root)
@@ -472,12 +1802,14 @@ func (t *rbTree) getKeyMaxLen() uint {
 }
 
 type node struct {
-	key    interface{}
-	value  interface{}
-	parent *node // parent
-	left   *node // left child
-	right  *node // right child
-	color  colours
+	key     interface{}
+	value   interface{}
+	parent  *node // parent
+	left    *node // left child
+	right   *node // right child
+	color   colours
+	version uint64 // bumped by every Put of key
+	size    int    // count of nodes in the subtree, 0 for the nil node
 }
 
 type colours uint8
@@ -489,7 +1821,25 @@ const (
 
 // newNodeForInsert returns a pointer to the new node containing the key/value, the new node must be red
 func (t *rbTree) newNodeForInsert(key interface{}, value interface{}, parent *node) *node {
-	return &node{key: key, value: value, color: red, parent: parent, left: t.nil, right: t.nil}
+	return &node{key: key, value: value, color: red, parent: parent, left: t.nil, right: t.nil, size: 1}
+}
+
+// touch gives n a new version after its value is stored.
+func (t *rbTree) touch(n *node) {
+	t.version++
+	n.version = t.version
+	if t.keyKind == reflect.Invalid && n.key != nil {
+		t.keyKind = reflect.TypeOf(n.key).Kind()
+	}
+}
+
+// touchAll gives each node of the subtree of n a new version in ASC, for the nodes stored by a bulk build.
+// O(N)
+func (t *rbTree) touchAll(n *node) {
+	t.walkAsc(n, func(n *node) bool {
+		t.touch(n)
+		return true
+	})
 }
 
 // O(logN)
@@ -531,7 +1881,66 @@ func (t *rbTree) predecessor(n *node) *node {
 		return t.max(n.left)
 	}
 
-	return n.parent
+	x := n
+	y := x.parent
+	for y != t.nil && x == y.left {
+		x = y
+		y = y.parent
+	}
+	return y
+}
+
+// walkAsc calls fn for each node of the subtree n in ASC until fn returns false.
+// It returns false if the walk was stopped by fn.
+func (t *rbTree) walkAsc(n *node, fn func(n *node) bool) bool {
+	if n == t.nil {
+		return true
+	}
+	return t.walkAsc(n.left, fn) && fn(n) && t.walkAsc(n.right, fn)
+}
+
+// walkDesc calls fn for each node of the subtree n in DESC until fn returns false.
+// It returns false if the walk was stopped by fn.
+func (t *rbTree) walkDesc(n *node, fn func(n *node) bool) bool {
+	if n == t.nil {
+		return true
+	}
+	return t.walkDesc(n.right, fn) && fn(n) && t.walkDesc(n.left, fn)
+}
+
+// extremeValue returns the first key-value in ASC whose value is better than all others, better(a, b) means a beats b.
+func (t *rbTree) extremeValue(better func(a, b interface{}) bool) (key, value interface{}, ok bool) {
+	var best *node
+	t.walkAsc(t.root, func(n *node) bool {
+		if best == nil || better(n.value, best.value) {
+			best = n
+		}
+		return true
+	})
+	if best == nil {
+		return nil, nil, false
+	}
+	return best.key, best.value, true
+}
+
+// walkPre calls fn for each node of the subtree n in pre-order.
+func (t *rbTree) walkPre(n *node, fn func(n *node)) {
+	if n == t.nil {
+		return
+	}
+	fn(n)
+	t.walkPre(n.left, fn)
+	t.walkPre(n.right, fn)
+}
+
+// walkPost calls fn for each node of the subtree n in post-order.
+func (t *rbTree) walkPost(n *node, fn func(n *node)) {
+	if n == t.nil {
+		return
+	}
+	t.walkPost(n.left, fn)
+	t.walkPost(n.right, fn)
+	fn(n)
 }
 
 func (t *rbTree) rangeAllAsc(n *node, res []pair.Pair, pos *int) {
@@ -612,6 +2021,74 @@ func (t *rbTree) rangeAsc(n *node, res []pair.Pair, minKey, maxKey interface{},
 	return res
 }
 
+// walkRange calls fn for each node of the subtree n in ASC whose key is in [minKey, maxKey].
+func (t *rbTree) walkRange(n *node, minKey, maxKey interface{}, fn func(n *node)) {
+	if n == t.nil {
+		return
+	}
+
+	cmpMin, cmpMax := t.cmp(n.key, minKey), t.cmp(n.key, maxKey) // cmp() may takes some time, so we just cmp one time.
+	if cmpMin > 0 {
+		t.walkRange(n.left, minKey, maxKey, fn)
+	}
+	if cmpMin >= 0 && cmpMax <= 0 {
+		fn(n)
+	}
+	if cmpMax < 0 {
+		t.walkRange(n.right, minKey, maxKey, fn)
+	}
+}
+
+// rangeMulti walks in ASC, intervals[*pos] is the first interval which may contain the keys not visited yet.
+func (t *rbTree) rangeMulti(n *node, res []pair.Pair, intervals [][2]interface{}, pos *int) []pair.Pair {
+	if n == t.nil || *pos >= len(intervals) {
+		return res
+	}
+
+	if t.cmp(n.key, intervals[*pos][0]) > 0 {
+		res = t.rangeMulti(n.left, res, intervals, pos)
+	}
+	for *pos < len(intervals) && t.cmp(n.key, intervals[*pos][1]) > 0 {
+		*pos++ // n.key and the rest keys are beyond the interval
+	}
+	if *pos >= len(intervals) {
+		return res
+	}
+	if t.cmp(n.key, intervals[*pos][0]) >= 0 {
+		res = append(res, pair.Pair{First: n.key, Second: n.value})
+	}
+	return t.rangeMulti(n.right, res, intervals, pos)
+}
+
+// ctxCheckInterval is how many nodes RangeCtx visits between two checks of ctx.
+const ctxCheckInterval = 256
+
+func (t *rbTree) rangeAscCtx(ctx context.Context, n *node, res []pair.Pair, minKey, maxKey interface{}, visited *int) ([]pair.Pair, error) {
+	if n == t.nil {
+		return res, nil
+	}
+	if *visited++; *visited%ctxCheckInterval == 0 {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+	}
+
+	var err error
+	cmpMin, cmpMax := t.cmp(n.key, minKey), t.cmp(n.key, maxKey) // cmp() may takes some time, so we just cmp one time.
+	if cmpMin > 0 {
+		if res, err = t.rangeAscCtx(ctx, n.left, res, minKey, maxKey, visited); err != nil {
+			return res, err
+		}
+	}
+	if cmpMin >= 0 && cmpMax <= 0 {
+		res = append(res, pair.Pair{First: n.key, Second: n.value})
+	}
+	if cmpMax < 0 {
+		return t.rangeAscCtx(ctx, n.right, res, minKey, maxKey, visited)
+	}
+	return res, nil
+}
+
 func (t *rbTree) rangeAscN(n *node, res []pair.Pair, num int, key interface{}, cmp CmpFunc) []pair.Pair {
 	if n == t.nil {
 		return res
@@ -684,6 +2161,9 @@ func (t *rbTree) check(n *node) (c *Check, ok bool) {
 	if n.color == black {
 		c.BlackH = 1
 	}
+	if n.size != n.left.size+n.right.size+1 {
+		return c, false
+	}
 	if n.left == t.nil && n.right == t.nil {
 		return c, true
 	} else if n.left != t.nil && n.right != t.nil {
@@ -716,7 +2196,7 @@ func (t *rbTree) check(n *node) (c *Check, ok bool) {
 		c.Len += cl.Len + cr.Len
 	} else if n.right == t.nil {
 		cl, ok := t.check(n.left)
-		if !ok || cl.Colour != red || cl.Len != 1 {
+		if !ok || cl.Colour != red || cl.Len != 1 || c.Colour == red {
 			return c, false
 		}
 
@@ -726,7 +2206,7 @@ func (t *rbTree) check(n *node) (c *Check, ok bool) {
 		c.MaxH += cl.MaxH
 	} else {
 		cr, ok := t.check(n.right)
-		if !ok || cr.Colour != red || cr.Len != 1 {
+		if !ok || cr.Colour != red || cr.Len != 1 || c.Colour == red {
 			return c, false
 		}
 
@@ -754,6 +2234,32 @@ func (t *rbTree) getDepth(n *node) uint {
 	}
 }
 
+// at returns the node of the i-th key in ASC, i starts from 0, or t.nil if out of range.
+// O(logN)
+func (t *rbTree) at(i int) *node {
+	if i < 0 || i >= t.len {
+		return t.nil
+	}
+	p := t.root
+	for p != t.nil {
+		if l := p.left.size; i < l {
+			p = p.left
+		} else if i == l {
+			break
+		} else {
+			i -= l + 1
+			p = p.right
+		}
+	}
+	return p
+}
+
+// count returns the count of nodes in the subtree n.
+// O(1)
+func (t *rbTree) count(n *node) int {
+	return n.size
+}
+
 // O(logN)
 func (t *rbTree) getLeftDepth(n *node) uint {
 	if n == t.nil {
@@ -763,7 +2269,7 @@ func (t *rbTree) getLeftDepth(n *node) uint {
 }
 
 // Deprecated: only for debugging, unstable function
-func (t *rbTree) makeString(n *node, buffs []*strings.Builder, step, lMove, tDepth, nDepth uint, ifRowFirst, ifParentRowFirst bool) {
+func (t *rbTree) makeString(n *node, buffs []*strings.Builder, step, lMove, tDepth, nDepth uint, ifRowFirst, ifParentRowFirst bool, label func(n *node) string) {
 	if n == t.nil {
 		return
 	}
@@ -783,7 +2289,7 @@ func (t *rbTree) makeString(n *node, buffs []*strings.Builder, step, lMove, tDep
 	}
 	// write key "[123456]B"
 	buffs[nDepth-1].WriteString("[")
-	strKey := fmt.Sprint(n.key)
+	strKey := label(n)
 	for i := int(step) - 3 - len(strKey); i > 0; i-- {
 		buffs[nDepth-1].WriteString(" ")
 	}
@@ -795,7 +2301,7 @@ func (t *rbTree) makeString(n *node, buffs []*strings.Builder, step, lMove, tDep
 	}
 
 	if n.left != t.nil {
-		t.makeString(n.left, buffs, step, lMove, tDepth, nDepth+1, ifRowFirst, false)
+		t.makeString(n.left, buffs, step, lMove, tDepth, nDepth+1, ifRowFirst, false, label)
 	} else if !ifRowFirst {
 		for i := nDepth + 1; i <= tDepth; i++ {
 			rStep := spaceCount(step, tDepth, i)
@@ -808,9 +2314,9 @@ func (t *rbTree) makeString(n *node, buffs []*strings.Builder, step, lMove, tDep
 
 	if n.right != t.nil {
 		if n.left == t.nil {
-			t.makeString(n.right, buffs, step, lMove, tDepth, nDepth+1, false, true)
+			t.makeString(n.right, buffs, step, lMove, tDepth, nDepth+1, false, true, label)
 		} else {
-			t.makeString(n.right, buffs, step, lMove, tDepth, nDepth+1, false, false)
+			t.makeString(n.right, buffs, step, lMove, tDepth, nDepth+1, false, false, label)
 		}
 	} else {
 		if ifRowFirst && n.left == t.nil {
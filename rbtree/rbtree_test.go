@@ -0,0 +1,1423 @@
+package rbtree
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/shengmingzhu/datastructures/pair"
+)
+
+func intCmp(a, b interface{}) int {
+	return a.(int) - b.(int)
+}
+
+// mustValid fails tb if tr is not a valid rbTree with strictly ASC keys.
+func mustValid(tb testing.TB, tr *rbTree) {
+	tb.Helper()
+	c, ok := tr.check(tr.root)
+	if !ok || c.Len != tr.Len() || tr.root.color != black || (tr.len > 0 && tr.root.parent != tr.nil) {
+		tb.Fatalf("invalid tree ok=%v c=%+v len=%d", ok, c, tr.Len())
+	}
+	keys := tr.Keys()
+	for i := 1; i < len(keys); i++ {
+		if tr.cmp(keys[i-1], keys[i]) >= 0 {
+			tb.Fatalf("not ordered %v", keys)
+		}
+	}
+}
+
+func intPairs(keys ...int) []pair.Pair {
+	res := make([]pair.Pair, len(keys))
+	for i, k := range keys {
+		res[i] = pair.Pair{First: k, Second: k * 10}
+	}
+	return res
+}
+
+func TestRecompare(t *testing.T) {
+	tr := New(intCmp)
+	for i := 0; i < 50; i++ {
+		tr.Put(i, i)
+	}
+	desc := tr.Recompare(func(a, b interface{}) int { return intCmp(b, a) })
+	mustValid(t, desc)
+	for i, k := range desc.Keys() {
+		if k != 49-i {
+			t.Fatalf("Keys got %v, want in DESC", desc.Keys())
+		}
+	}
+	// Keys the same under newCmp keep the first one in the order of t.
+	mod := tr.Recompare(func(a, b interface{}) int { return intCmp(a.(int)%10, b.(int)%10) })
+	mustValid(t, mod)
+	if got := mod.Keys(); !reflect.DeepEqual(got, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Fatalf("Keys got %v", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Recompare with an inconsistent cmp didn't panic")
+		}
+	}()
+	tr.Recompare(func(a, b interface{}) int { return 1 }) // every key is greater than the others
+}
+
+func TestKeyKind(t *testing.T) {
+	tr := New(intCmp)
+	if got := tr.KeyKind(); got != reflect.Invalid {
+		t.Fatalf("KeyKind of an empty tree got %v", got)
+	}
+	tr.Put(1, nil)
+	tr.Put(2, nil)
+	if got := tr.KeyKind(); got != reflect.Int {
+		t.Fatalf("KeyKind got %v, want %v", got, reflect.Int)
+	}
+	tr.Delete(1)
+	tr.Delete(2)
+	if got := tr.KeyKind(); got != reflect.Invalid {
+		t.Fatalf("KeyKind after deleting all keys got %v", got)
+	}
+
+	st := NewStringTree()
+	st.Put("a", 1)
+	if got := st.KeyKind(); got != reflect.String {
+		t.Fatalf("KeyKind got %v, want %v", got, reflect.String)
+	}
+	tr.Put(3, nil)
+	if got := NewFromSortedDesc(intCmp, tr.RangeAllDesc()).KeyKind(); got != reflect.Int {
+		t.Fatalf("KeyKind of a bulk build got %v, want %v", got, reflect.Int)
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	tr := New(intCmp)
+	for i := 0; i < 100; i++ {
+		tr.Put(i, i)
+	}
+	_, before, _ := tr.GetWithVersion(99)
+
+	pairs := intPairs(1, 3, 5, 7, 9, 11)
+	tr.ReplaceAll(pairs)
+	mustValid(t, tr)
+	if !reflect.DeepEqual(tr.RangeAll(), pairs) {
+		t.Fatalf("got %v, want %v", tr.RangeAll(), pairs)
+	}
+	if got := tr.ChangedSince(before); !reflect.DeepEqual(got, pairs) {
+		t.Fatalf("ChangedSince got %v, want %v", got, pairs)
+	}
+	if _, v, _ := tr.GetWithVersion(5); v == 0 {
+		t.Fatal("a reloaded key has version 0")
+	}
+	if tr.PutIfVersion(5, 0, 0) {
+		t.Fatal("PutIfVersion(key, value, 0) overwrote a present key")
+	}
+
+	tr.ReplaceAll(nil)
+	mustValid(t, tr)
+	if tr.Len() != 0 {
+		t.Fatalf("Len %d after ReplaceAll(nil)", tr.Len())
+	}
+}
+
+func TestBulkBuildVersions(t *testing.T) {
+	pairs := intPairs(1, 2, 3)
+	trees := map[string]*rbTree{
+		"NewFromSortedDesc": NewFromSortedDesc(intCmp, intPairs(3, 2, 1)),
+		"NewFromPreOrder":   NewFromPreOrder(intCmp, intPairs(2, 1, 3), []bool{false, false, false}),
+	}
+	imported, err := ImportStructure(intCmp, trees["NewFromSortedDesc"].ExportStructure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	trees["ImportStructure"] = imported
+	for name, tr := range trees {
+		if got := tr.ChangedSince(0); !reflect.DeepEqual(got, pairs) {
+			t.Fatalf("%s: ChangedSince(0) got %v", name, got)
+		}
+		if tr.PutIfVersion(2, 0, 0) {
+			t.Fatalf("%s: PutIfVersion(key, value, 0) overwrote a present key", name)
+		}
+	}
+}
+
+func TestNewFromPreOrder(t *testing.T) {
+	tr := New(intCmp)
+	for i := 0; i < 50; i++ {
+		tr.Put(i*7%50, i)
+	}
+	pairs, isRed := tr.PreOrderColors()
+	rebuilt := NewFromPreOrder(intCmp, pairs, isRed)
+	mustValid(t, rebuilt)
+	if got, _ := rebuilt.PreOrderColors(); !reflect.DeepEqual(got, pairs) {
+		t.Fatal("the rebuilt tree has another shape")
+	}
+
+	// The colors are valid for the shapes, only the order of the keys is broken.
+	invalid := map[string]struct {
+		keys  []int
+		isRed []bool
+	}{
+		"less than root in the right subtree": {[]int{2, 0, 4, 1}, []bool{false, false, false, true}},
+		"out of order":                        {[]int{5, 3, 4, 1}, []bool{false, false, false, false}},
+		"duplicate key":                       {[]int{2, 1, 2}, []bool{false, true, true}},
+	}
+	for name, c := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s: no panic", name)
+				}
+			}()
+			NewFromPreOrder(intCmp, intPairs(c.keys...), c.isRed)
+		}()
+	}
+}
+
+// mustSizes fails tb if a node's size is not the count of its subtree, or at disagrees with the walk in ASC.
+func mustSizes(tb testing.TB, tr *rbTree) {
+	tb.Helper()
+	mustValid(tb, tr) // check verifies n.size == n.left.size + n.right.size + 1 for each node
+	if tr.root.size != tr.Len() || tr.nil.size != 0 {
+		tb.Fatalf("root size %d, nil size %d, Len %d", tr.root.size, tr.nil.size, tr.Len())
+	}
+	for i, k := range tr.Keys() {
+		if got := tr.at(i).key; got != k {
+			tb.Fatalf("at(%d) got %v, want %v", i, got, k)
+		}
+	}
+}
+
+func TestSizeAndRangeCount(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tr := New(intCmp)
+	for round := 0; round < 200; round++ {
+		for i := 0; i < 20; i++ {
+			k := r.Intn(300)
+			switch r.Intn(7) {
+			case 0, 1, 2:
+				tr.Put(k, i)
+			case 3:
+				tr.Delete(k)
+			case 4:
+				tr.PopMin()
+			case 5:
+				tr.PopMax()
+			case 6:
+				tr.DeleteAt(k)
+			}
+		}
+		switch round % 50 {
+		case 10:
+			tr.Rebuild()
+		case 20:
+			tr = NewFromSortedDesc(intCmp, tr.RangeAllDesc())
+		case 30:
+			tr.ReplaceAll(tr.RangeAll())
+		case 40:
+			tr.RetainIf(func(key, _ interface{}) bool { return key.(int)%3 != 0 })
+		}
+		mustSizes(t, tr)
+
+		for i := 0; i < 20; i++ {
+			lo, hi := r.Intn(320)-10, r.Intn(320)-10
+			if got, want := tr.RangeCount(lo, hi), len(tr.Range(lo, hi)); got != want {
+				t.Fatalf("RangeCount(%d, %d) got %d, want %d", lo, hi, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkRangeCount(b *testing.B) {
+	tr := New(intCmp)
+	for i := 0; i < 100000; i++ {
+		tr.Put(i, nil)
+	}
+	b.Run("RangeCount", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			lo := i % 50000
+			tr.RangeCount(lo, lo+50000)
+		}
+	})
+	b.Run("len(Range)", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			lo := i % 50000
+			_ = len(tr.Range(lo, lo+50000))
+		}
+	})
+}
+
+func TestNeighbors(t *testing.T) {
+	tr := New(intCmp)
+	if _, _, _, _, hasPrev, hasNext := tr.Neighbors(1); hasPrev || hasNext {
+		t.Fatal("Neighbors on an empty tree found a key")
+	}
+	for i := 1; i <= 15; i++ {
+		tr.Put(i, i*10)
+	}
+	// Each key is checked, which covers the nodes with a left subtree and the nodes which are a left child,
+	// the two ways predecessor goes.
+	var withLeft, leftChild bool
+	for i := 1; i <= 15; i++ {
+		n := tr.search(i)
+		withLeft = withLeft || n.left != tr.nil
+		leftChild = leftChild || (n.parent != tr.nil && n.parent.left == n)
+
+		pk, pv, nk, nv, hasPrev, hasNext := tr.Neighbors(i)
+		if hasPrev != (i > 1) || (hasPrev && (pk != i-1 || pv != (i-1)*10)) {
+			t.Fatalf("Neighbors(%d) prev got %v %v %v", i, pk, pv, hasPrev)
+		}
+		if hasNext != (i < 15) || (hasNext && (nk != i+1 || nv != (i+1)*10)) {
+			t.Fatalf("Neighbors(%d) next got %v %v %v", i, nk, nv, hasNext)
+		}
+	}
+	if !withLeft || !leftChild {
+		t.Fatal("the tree has no node with a left subtree or no left child")
+	}
+	if _, _, _, _, hasPrev, hasNext := tr.Neighbors(16); hasPrev || hasNext {
+		t.Fatal("Neighbors of an absent key found a key")
+	}
+}
+
+func TestNewCapped(t *testing.T) {
+	for _, c := range []struct {
+		policy  EvictPolicy
+		evicted []int
+		keys    []interface{}
+	}{
+		{EvictMin, []int{1, 2, 0}, []interface{}{3, 4, 5}},
+		{EvictMax, []int{4, 5, 3}, []interface{}{0, 1, 2}},
+	} {
+		var evicted []int
+		tr := NewCapped(intCmp, 3, c.policy, func(key, value interface{}) {
+			if value != key.(int)*10 {
+				t.Fatalf("onEvict got %v %v", key, value)
+			}
+			evicted = append(evicted, key.(int))
+		})
+		for _, k := range []int{3, 1, 2, 4, 3, 5, 0} {
+			tr.Put(k, k*10)
+		}
+		mustValid(t, tr)
+		// A key put beyond the evicted end is evicted at once, as 0 for EvictMin and 4, 5 for EvictMax.
+		if !reflect.DeepEqual(evicted, c.evicted) || !reflect.DeepEqual(tr.Keys(), c.keys) {
+			t.Fatalf("policy %d: evicted %v with keys %v, want %v with %v", c.policy, evicted, tr.Keys(), c.evicted, c.keys)
+		}
+	}
+}
+
+func TestGetOrPutAll(t *testing.T) {
+	tr := New(intCmp)
+	tr.Put(1, "one")
+	tr.Put(3, "three")
+	res := tr.GetOrPutAll([]pair.Pair{{First: 1, Second: "x"}, {First: 2, Second: "two"}, {First: 3, Second: "y"}, {First: 2, Second: "z"}})
+	want := []pair.Pair{{First: 1, Second: "one"}, {First: 2, Second: "two"}, {First: 3, Second: "three"}, {First: 2, Second: "two"}}
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("GetOrPutAll got %v, want %v", res, want)
+	}
+	if got := tr.RangeAll(); !reflect.DeepEqual(got, want[:3]) {
+		t.Fatalf("RangeAll got %v, want %v", got, want[:3])
+	}
+	mustValid(t, tr)
+}
+
+func TestRebuild(t *testing.T) {
+	tr := New(intCmp)
+	for i := 0; i < 1<<12; i++ {
+		tr.Put(i, i)
+	}
+	// Deleting the most keys leaves a tree taller than needed.
+	r := rand.New(rand.NewSource(1))
+	for _, k := range r.Perm(1 << 12)[:1<<12-100] {
+		tr.Delete(k)
+	}
+	want := tr.RangeAll()
+	before, _ := tr.check(tr.root)
+
+	tr.Rebuild()
+	mustSizes(t, tr)
+	after, _ := tr.check(tr.root)
+	if after.MaxH >= before.MaxH || after.MaxH != 7 {
+		t.Fatalf("MaxH got %d after Rebuild, %d before, want 7 for 100 keys", after.MaxH, before.MaxH)
+	}
+	if got := tr.RangeAll(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("RangeAll after Rebuild got %v, want %v", got, want)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tr := New(intCmp)
+	if got := tr.Compact(); got != "{}" {
+		t.Fatalf("Compact of an empty tree got %q", got)
+	}
+	for _, k := range []int{3, 1, 2} {
+		tr.Put(k, string(rune('a'+k-1)))
+	}
+	if got := tr.Compact(); got != "{1:a, 2:b, 3:c}" {
+		t.Fatalf("Compact got %q", got)
+	}
+	for limit, want := range map[int]string{
+		-1: "{1:a, 2:b, 3:c}",
+		0:  "{1:a, 2:b, 3:c}",
+		1:  "{1:a, ...(+2)}",
+		2:  "{1:a, 2:b, ...(+1)}",
+		3:  "{1:a, 2:b, 3:c}",
+		4:  "{1:a, 2:b, 3:c}",
+	} {
+		if got := tr.CompactN(limit); got != want {
+			t.Fatalf("CompactN(%d) got %q, want %q", limit, got, want)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		tr.Put(i+10, i)
+	}
+	if got := tr.Compact(); !strings.HasSuffix(got, ", 70:60, ...(+939)}") {
+		t.Fatalf("Compact of %d key-values got %q, want %d printed", tr.Len(), got, DefaultCompactLimit)
+	}
+}
+
+func TestPutIfVersion(t *testing.T) {
+	tr := New(intCmp)
+	tr.Put(1, "a")
+	_, v1, ok := tr.GetWithVersion(1)
+	if !ok || v1 == 0 {
+		t.Fatalf("GetWithVersion got %d %v", v1, ok)
+	}
+	if !tr.PutIfVersion(1, "b", v1) {
+		t.Fatal("PutIfVersion with the current version failed")
+	}
+	value, v2, _ := tr.GetWithVersion(1)
+	if value != "b" || v2 <= v1 {
+		t.Fatalf("GetWithVersion after the CAS got %v %d, want b with a version > %d", value, v2, v1)
+	}
+	if tr.PutIfVersion(1, "c", v1) {
+		t.Fatal("PutIfVersion with a stale version succeeded")
+	}
+	tr.Put(1, "d") // a Put makes v2 stale too
+	if tr.PutIfVersion(1, "e", v2) {
+		t.Fatal("PutIfVersion with the version before a Put succeeded")
+	}
+	if value, _ := tr.Get(1); value != "d" {
+		t.Fatalf("Get got %v, want d", value)
+	}
+
+	if tr.PutIfVersion(2, "x", v1) {
+		t.Fatal("PutIfVersion of an absent key with a version > 0 succeeded")
+	}
+	if !tr.PutIfVersion(2, "x", 0) || tr.PutIfVersion(2, "y", 0) {
+		t.Fatal("PutIfVersion with version 0 must insert only an absent key")
+	}
+	if value, _, _ := tr.GetWithVersion(3); value != nil {
+		t.Fatalf("GetWithVersion of an absent key got %v", value)
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	tr := New(intCmp)
+	for i := 0; i < 100; i++ {
+		tr.Put(i, i)
+	}
+	_, mark, _ := tr.GetWithVersion(99)
+	if got := tr.ChangedSince(mark); len(got) != 0 {
+		t.Fatalf("ChangedSince the last version got %v", got)
+	}
+
+	// A batch of Puts, overwriting some keys, some of them more than once, and inserting others.
+	for _, k := range []int{50, 7, 120, 50, 3, 101} {
+		tr.Put(k, -k)
+	}
+	tr.Delete(3)
+	want := []pair.Pair{{First: 7, Second: -7}, {First: 50, Second: -50}, {First: 101, Second: -101}, {First: 120, Second: -120}}
+	if got := tr.ChangedSince(mark); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ChangedSince got %v, want %v", got, want)
+	}
+	if got := tr.ChangedSince(0); len(got) != tr.Len() {
+		t.Fatalf("ChangedSince(0) got %d key-values, want %d", len(got), tr.Len())
+	}
+}
+
+func TestRetainIf(t *testing.T) {
+	tr := New(intCmp)
+	var evens []interface{}
+	for i := 0; i < 1001; i++ {
+		tr.Put(i, i)
+		if i%2 == 0 {
+			evens = append(evens, i)
+		}
+	}
+	if n := tr.RetainIf(func(key, _ interface{}) bool { return key.(int)%2 == 0 }); n != 500 {
+		t.Fatalf("RetainIf got %d removed, want 500", n)
+	}
+	mustSizes(t, tr)
+	if !reflect.DeepEqual(tr.Keys(), evens) {
+		t.Fatalf("Keys after RetainIf got %v", tr.Keys())
+	}
+	if n := tr.RetainIf(func(_, _ interface{}) bool { return true }); n != 0 {
+		t.Fatalf("RetainIf keeping all got %d removed", n)
+	}
+	if n := tr.RetainIf(func(_, _ interface{}) bool { return false }); n != len(evens) || tr.Len() != 0 {
+		t.Fatalf("RetainIf keeping none got %d removed, Len %d", n, tr.Len())
+	}
+	mustValid(t, tr)
+}
+
+func TestRangeMulti(t *testing.T) {
+	tr := New(intCmp)
+	for i := 0; i < 100; i += 2 {
+		tr.Put(i, i)
+	}
+	for _, intervals := range [][][2]int{
+		nil,
+		{{10, 20}},
+		{{10, 20}, {21, 30}},      // adjacent, 20 is in the first only
+		{{-5, 3}, {4, 4}, {5, 8}}, // boundaries on present keys
+		{{1, 1}, {3, 5}, {97, 200}},
+		{{0, 98}},
+	} {
+		var want []pair.Pair
+		arg := make([][2]interface{}, len(intervals))
+		for i, iv := range intervals {
+			want = append(want, tr.Range(iv[0], iv[1])...)
+			arg[i] = [2]interface{}{iv[0], iv[1]}
+		}
+		got := tr.RangeMulti(arg)
+		if len(got) != len(want) || (len(got) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Fatalf("RangeMulti(%v) got %v, want %v", intervals, got, want)
+		}
+	}
+
+	for name, intervals := range map[string][][2]interface{}{
+		"min > max":  {{5, 3}},
+		"overlapped": {{1, 5}, {5, 9}},
+		"unsorted":   {{10, 20}, {1, 5}},
+	} {
+		mustPanic(t, "RangeMulti with "+name+" intervals", func() { tr.RangeMulti(intervals) })
+	}
+}
+
+func TestTrim(t *testing.T) {
+	heap := func() uint64 {
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+	base := heap()
+	tr := New(intCmp)
+	for i := 0; i < 100000; i++ {
+		tr.Put(i, make([]byte, 256))
+	}
+	full := heap()
+	for i := 100; i < 100000; i++ {
+		tr.Delete(i)
+	}
+	tr.Trim()
+	trimmed := heap()
+	// Loose: the deleted nodes and values, over 25MB, must not be retained, at least half of the growth is released.
+	if full <= base || trimmed > base+(full-base)/2 {
+		t.Fatalf("HeapAlloc got %d before, %d full, %d trimmed", base, full, trimmed)
+	}
+	mustValid(t, tr)
+	runtime.KeepAlive(tr)
+}
+
+// BenchmarkReserve reports the allocations of 1000 Puts after Reserve(1000), which are the same as without Reserve
+// as long as there is no node pool.
+func BenchmarkReserve(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tr := New(intCmp)
+		tr.Reserve(1000)
+		for k := 0; k < 1000; k++ {
+			tr.Put(k, nil)
+		}
+	}
+}
+
+func TestRandomPutDelete(t *testing.T) {
+	r := rand.New(rand.NewSource(33))
+	tr := New(intCmp)
+	ref := make(map[int]int)
+	for i := 0; i < 20000; i++ {
+		k := r.Intn(2000)
+		if r.Intn(2) == 0 {
+			tr.Put(k, i)
+			ref[k] = i
+		} else {
+			tr.Delete(k)
+			delete(ref, k)
+		}
+		if i%1000 == 0 {
+			mustValid(t, tr)
+		}
+	}
+	mustSizes(t, tr)
+	if tr.Len() != len(ref) {
+		t.Fatalf("Len got %d, want %d", tr.Len(), len(ref))
+	}
+	for k, v := range ref {
+		if got, ok := tr.Get(k); !ok || got != v {
+			t.Fatalf("Get(%d) got %v %v, want %d", k, got, ok, v)
+		}
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	keys := rand.New(rand.NewSource(1)).Perm(100000)
+	tr := New(intCmp)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := keys[i%len(keys)]
+		if i%len(keys) == 0 {
+			b.StopTimer()
+			for _, k := range keys {
+				tr.Put(k, nil)
+			}
+			b.StartTimer()
+		}
+		tr.Delete(k)
+	}
+}
+
+func TestCompositeCmp(t *testing.T) {
+	strCmp := func(a, b interface{}) int { return strings.Compare(a.(string), b.(string)) }
+	tr := New(CompositeCmp(strCmp, strCmp, intCmp))
+	want := []interface{}{
+		CompositeKey{"a"}, CompositeKey{"a", "b"}, CompositeKey{"a", "b", 1}, CompositeKey{"a", "b", 3},
+		CompositeKey{"a", "z", 2}, CompositeKey{"b", "a", 1},
+	}
+	for _, i := range []int{5, 4, 3, 2, 0, 1} {
+		tr.Put(want[i], nil)
+	}
+	if !reflect.DeepEqual(tr.Keys(), want) {
+		t.Fatalf("Keys got %v, want %v", tr.Keys(), want)
+	}
+}
+
+func TestByField(t *testing.T) {
+	// By age, then by id in DESC on ties.
+	type person struct{ age, id int }
+	tr := New(ByField(func(k interface{}) int64 { return int64(k.(person).age) }, func(k interface{}) int64 { return -int64(k.(person).id) }))
+	for _, p := range []person{{30, 1}, {20, 5}, {30, 2}, {20, 7}} {
+		tr.Put(p, nil)
+	}
+	if want := []interface{}{person{20, 7}, person{20, 5}, person{30, 2}, person{30, 1}}; !reflect.DeepEqual(tr.Keys(), want) {
+		t.Fatalf("Keys got %v, want %v", tr.Keys(), want)
+	}
+}
+
+func TestCmp(t *testing.T) {
+	tr := New(intCmp)
+	f := tr.Cmp()
+	for _, c := range [][2]int{{1, 2}, {2, 1}, {3, 3}, {-5, 5}} {
+		if got, want := f(c[0], c[1]), intCmp(c[0], c[1]); got != want {
+			t.Fatalf("Cmp()(%d, %d) got %d, want %d", c[0], c[1], got, want)
+		}
+	}
+}
+
+func TestNewFromSortedDesc(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 4, 7, 8, 15, 16, 255, 256} {
+		ps := make([]pair.Pair, n)
+		for i := range ps {
+			ps[i] = pair.Pair{First: n - i, Second: (n - i) * 10}
+		}
+		tr := NewFromSortedDesc(intCmp, ps)
+		mustSizes(t, tr)
+		want := make([]pair.Pair, n)
+		for i := range want {
+			want[i] = ps[n-1-i]
+		}
+		if got := tr.RangeAll(); len(got) != n || (n > 0 && !reflect.DeepEqual(got, want)) {
+			t.Fatalf("n = %d: RangeAll got %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestEntries(t *testing.T) {
+	tr := New(intCmp)
+	for _, p := range intPairs(5, 3, 8, 1, 4, 9, 7) {
+		tr.Put(p.First, p.Second)
+	}
+	asc, desc := tr.RangeAll(), tr.RangeAllDesc()
+	es, ed := tr.Entries(), tr.EntriesDesc()
+	if len(es) != len(asc) || len(ed) != len(desc) {
+		t.Fatalf("Entries got %d and %d, want %d", len(es), len(ed), len(asc))
+	}
+	for i := range asc {
+		if es[i] != (Entry{Key: asc[i].First, Value: asc[i].Second}) || ed[i] != (Entry{Key: desc[i].First, Value: desc[i].Second}) {
+			t.Fatalf("Entries got %v, RangeAll %v", es, asc)
+		}
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	tr := New(intCmp)
+	for _, p := range intPairs(5, 3, 8, 1) {
+		tr.Put(p.First, p.Second)
+	}
+	// Duplicates appear once, absent keys are skipped, the result is in the key order.
+	if got, want := tr.GetMulti([]interface{}{8, 2, 3, 8, 1, 3, 9}), intPairs(1, 3, 8); !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetMulti got %v, want %v", got, want)
+	}
+	if got := tr.GetMulti([]interface{}{2, 4}); len(got) != 0 {
+		t.Fatalf("GetMulti of absent keys got %v", got)
+	}
+}
+
+// nilFirstCmp compares int keys, nil is less than any int.
+func nilFirstCmp(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == b:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return intCmp(a, b)
+}
+
+func TestMinMaxOk(t *testing.T) {
+	tr := New(nilFirstCmp)
+	if k, v, ok := tr.MinOk(); ok {
+		t.Fatalf("MinOk of an empty tree got %v %v", k, v)
+	}
+	if k, v, ok := tr.MaxOk(); ok {
+		t.Fatalf("MaxOk of an empty tree got %v %v", k, v)
+	}
+	if k, v := tr.Min(); k != nil || v != nil {
+		t.Fatalf("Min of an empty tree got %v %v", k, v)
+	}
+	if k, v := tr.PopMax(); k != nil || v != nil {
+		t.Fatalf("PopMax of an empty tree got %v %v", k, v)
+	}
+
+	// The min key is nil, Min can't tell it from an empty tree but MinOk can.
+	tr.Put(3, "c")
+	tr.Put(nil, "nil")
+	if k, v, ok := tr.MinOk(); !ok || k != nil || v != "nil" {
+		t.Fatalf("MinOk got %v %v %v", k, v, ok)
+	}
+	if k, v, ok := tr.MaxOk(); !ok || k != 3 || v != "c" {
+		t.Fatalf("MaxOk got %v %v %v", k, v, ok)
+	}
+	if k, v := tr.PopMin(); k != nil || v != "nil" || tr.Len() != 1 {
+		t.Fatalf("PopMin got %v %v, Len %d", k, v, tr.Len())
+	}
+	if k, v := tr.PopMax(); k != 3 || v != "c" || tr.Len() != 0 {
+		t.Fatalf("PopMax got %v %v, Len %d", k, v, tr.Len())
+	}
+}
+
+func TestLevelOrder(t *testing.T) {
+	tr := New(intCmp)
+	if got := tr.LevelOrder(); len(got) != 0 {
+		t.Fatalf("LevelOrder of an empty tree got %v", got)
+	}
+	// Putting 1 to 7 in ASC rotates to:
+	//       2
+	//   1       4
+	//         3   6
+	//            5 7
+	for _, p := range intPairs(1, 2, 3, 4, 5, 6, 7) {
+		tr.Put(p.First, p.Second)
+	}
+	want := [][]pair.Pair{intPairs(2), intPairs(1, 4), intPairs(3, 6), intPairs(5, 7)}
+	if got := tr.LevelOrder(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("LevelOrder got %v, want %v", got, want)
+	}
+}
+
+func TestDeleteAt(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	tr := New(intCmp)
+	for i := 0; i < 300; i++ {
+		tr.Put(r.Intn(1000), i)
+	}
+	want := tr.Keys()
+	var got []interface{}
+	for k, _, ok := tr.MinOk(); ok; k, _, ok = tr.DeleteAt(k) {
+		got = append(got, k)
+		if tr.Len()%16 == 0 {
+			mustValid(t, tr)
+		}
+	}
+	if tr.Len() != 0 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("DeleteAt from Min walked %v, Len %d, want %v", got, tr.Len(), want)
+	}
+	if _, _, ok := tr.DeleteAt(1); ok {
+		t.Fatal("DeleteAt on an empty tree got true")
+	}
+}
+
+func TestKeysFunc(t *testing.T) {
+	tr := New(intCmp)
+	for i := 20; i > 0; i-- {
+		tr.Put(i, nil)
+	}
+	var got []interface{}
+	tr.KeysFunc(func(key interface{}) bool {
+		got = append(got, key)
+		return true
+	})
+	if !reflect.DeepEqual(got, tr.Keys()) {
+		t.Fatalf("KeysFunc visited %v, want %v", got, tr.Keys())
+	}
+	got = nil
+	tr.KeysFunc(func(key interface{}) bool {
+		got = append(got, key)
+		return key.(int) < 5
+	})
+	if !reflect.DeepEqual(got, tr.Keys()[:5]) {
+		t.Fatalf("KeysFunc stopping at 5 visited %v", got)
+	}
+}
+
+func TestLeftRightCount(t *testing.T) {
+	tr := New(intCmp)
+	if tr.LeftCount() != 0 || tr.RightCount() != 0 {
+		t.Fatalf("an empty tree got LeftCount %d, RightCount %d", tr.LeftCount(), tr.RightCount())
+	}
+	r := rand.New(rand.NewSource(9))
+	for i := 0; i < 500; i++ {
+		if r.Intn(4) == 0 {
+			tr.Delete(r.Intn(300))
+		} else {
+			tr.Put(r.Intn(300), nil)
+		}
+		if tr.Len() > 0 && tr.LeftCount()+tr.RightCount()+1 != tr.Len() {
+			t.Fatalf("LeftCount %d + RightCount %d + 1 != Len %d", tr.LeftCount(), tr.RightCount(), tr.Len())
+		}
+	}
+}
+
+func TestPutIfEqual(t *testing.T) {
+	tr := New(intCmp)
+	eq := func(a, b interface{}) bool { return a == b }
+	if tr.PutIfEqual(1, "a", "x", eq) || tr.Len() != 0 {
+		t.Fatal("PutIfEqual of an absent key with expectedOld got true")
+	}
+	if !tr.PutIfEqual(1, "a", nil, eq) {
+		t.Fatal("PutIfEqual of an absent key with nil expectedOld got false")
+	}
+	if tr.PutIfEqual(1, "b", "x", eq) {
+		t.Fatal("PutIfEqual with a mismatched value got true")
+	}
+	if v, _ := tr.Get(1); v != "a" {
+		t.Fatalf("Get after a mismatch got %v, want a", v)
+	}
+	if !tr.PutIfEqual(1, "b", "a", eq) {
+		t.Fatal("PutIfEqual with the matched value got false")
+	}
+	if v, _ := tr.Get(1); v != "b" {
+		t.Fatalf("Get after a match got %v, want b", v)
+	}
+}
+
+func TestKeysCopyInto(t *testing.T) {
+	tr := New(intCmp)
+	for i := 9; i >= 0; i-- {
+		tr.Put(i, nil)
+	}
+	for _, size := range []int{0, 1, 4, 10, 20} {
+		dst := make([]interface{}, size)
+		n := tr.KeysCopyInto(dst)
+		want := size
+		if want > tr.Len() {
+			want = tr.Len()
+		}
+		if n != want || !reflect.DeepEqual(dst[:n], tr.Keys()[:n]) {
+			t.Fatalf("KeysCopyInto of %d got %d, %v", size, n, dst)
+		}
+		for _, k := range dst[n:] {
+			if k != nil {
+				t.Fatalf("KeysCopyInto of %d wrote past %d: %v", size, n, dst)
+			}
+		}
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	h := func(key, value interface{}) uint64 {
+		f := fnv.New64a()
+		fmt.Fprint(f, key, "=", value)
+		return f.Sum64()
+	}
+	tr := New(intCmp)
+	for i := 0; i < 20; i++ {
+		tr.Put(i, i)
+	}
+	before := tr.ContentHash(h)
+	tr.Put(100, 1)
+	if tr.ContentHash(h) == before {
+		t.Fatal("ContentHash after a Put got the hash before")
+	}
+	tr.Delete(100)
+	if got := tr.ContentHash(h); got != before {
+		t.Fatalf("ContentHash after Put then Delete got %x, want %x", got, before)
+	}
+	tr.Put(3, 4)
+	if tr.ContentHash(h) == before {
+		t.Fatal("ContentHash after a value change got the hash before")
+	}
+}
+
+func TestRangeCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// cmp cancels ctx in the middle of the walk once calls reaches 1000.
+	calls := -1
+	tr := New(func(a, b interface{}) int {
+		if calls >= 0 {
+			if calls++; calls == 1000 {
+				cancel()
+			}
+		}
+		return intCmp(a, b)
+	})
+	for i := 0; i < 5000; i++ {
+		tr.Put(i, i)
+	}
+
+	if res, err := tr.RangeCtx(context.Background(), 10, 4000); err != nil || len(res) != 3991 {
+		t.Fatalf("RangeCtx got %d key-values, %v", len(res), err)
+	}
+	calls = 0
+	res, err := tr.RangeCtx(ctx, 0, 5000)
+	if err != context.Canceled || len(res) == 0 || len(res) >= 5000 {
+		t.Fatalf("RangeCtx canceled in the walk got %d key-values, %v", len(res), err)
+	}
+	for i := range res {
+		if res[i].First != i {
+			t.Fatalf("RangeCtx canceled got %v at %d", res[i], i)
+		}
+	}
+	if res, err := tr.RangeCtx(ctx, 0, 5000); err != context.Canceled || len(res) != 0 {
+		t.Fatalf("RangeCtx of a canceled ctx got %d key-values, %v", len(res), err)
+	}
+}
+
+func TestOverwriteCount(t *testing.T) {
+	tr := NewCountingOverwrites(intCmp)
+	for i := 0; i < 10; i++ {
+		tr.Put(i, i)
+	}
+	if n := tr.OverwriteCount(); n != 0 {
+		t.Fatalf("OverwriteCount after fresh Puts got %d", n)
+	}
+	for i := 5; i < 15; i++ {
+		tr.Put(i, -i)
+	}
+	if n := tr.OverwriteCount(); n != 5 {
+		t.Fatalf("OverwriteCount after 5 collisions got %d", n)
+	}
+	// A tree by New doesn't count.
+	tr = New(intCmp)
+	tr.Put(1, 1)
+	tr.Put(1, 2)
+	if n := tr.OverwriteCount(); n != 0 {
+		t.Fatalf("OverwriteCount of New got %d", n)
+	}
+}
+
+func TestCountLessGreater(t *testing.T) {
+	r := rand.New(rand.NewSource(12))
+	tr := New(intCmp)
+	for i := 0; i < 300; i++ {
+		tr.Put(r.Intn(1000), nil)
+	}
+	for k := -5; k < 1005; k++ {
+		present := 0
+		if _, ok := tr.Get(k); ok {
+			present = 1
+		}
+		if less, greater := tr.CountLess(k), tr.CountGreater(k); less+present+greater != tr.Len() {
+			t.Fatalf("key %d: CountLess %d + %d + CountGreater %d != Len %d", k, less, present, greater, tr.Len())
+		}
+	}
+	if n := tr.CountLess(500); n != len(tr.Range(-1, 499)) {
+		t.Fatalf("CountLess(500) got %d, want %d", n, len(tr.Range(-1, 499)))
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	tr := New(intCmp)
+	project := func(key interface{}) float64 { return float64(key.(int)) }
+	if q := tr.Quantile(0.5, project); !math.IsNaN(q) {
+		t.Fatalf("Quantile of an empty tree got %v, want NaN", q)
+	}
+	for i := 100; i >= 1; i-- {
+		tr.Put(i, nil)
+	}
+	for q, want := range map[float64]float64{0: 1, 0.5: 50, 0.99: 99, 1: 100} {
+		if got := tr.Quantile(q, project); got != want {
+			t.Fatalf("Quantile(%v) of 1..100 got %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestPretty(t *testing.T) {
+	tr := New(intCmp)
+	tr.Put(1, nil)
+	tr.Put(2, struct{ A, B int }{1, 2})
+	called := 0
+	got := tr.Pretty(func(key interface{}) string {
+		return fmt.Sprintf("k%d", key.(int))
+	}, func(value interface{}) string {
+		called++
+		return "v"
+	})
+	// 1 is the root, 2 the right child, which is padded to the width of the widest node.
+	if !strings.Contains(got, "[k1:<nil>]B") || !strings.Contains(got, "k2:v]R") || called != 1 {
+		t.Fatalf("Pretty got\n%s\nformatValue called %d times", got, called)
+	}
+	if got := tr.Pretty(nil, nil); !strings.Contains(got, "2:{1 2}]R") {
+		t.Fatalf("Pretty with fmt.Sprint got\n%s", got)
+	}
+	if got := New(intCmp).Pretty(nil, nil); strings.TrimSpace(got) != "" {
+		t.Fatalf("Pretty of an empty tree got %q", got)
+	}
+}
+
+func TestEntriesBatched(t *testing.T) {
+	tr := New(intCmp)
+	for i := 0; i < 105; i++ {
+		tr.Put(i, i*10)
+	}
+	var all []pair.Pair
+	var sizes []int
+	for batch := range tr.EntriesBatched(10) {
+		sizes = append(sizes, len(batch))
+		all = append(all, batch...)
+	}
+	if want := []int{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 5}; !reflect.DeepEqual(sizes, want) {
+		t.Fatalf("EntriesBatched(10) got batches of %v, want %v", sizes, want)
+	}
+	if !reflect.DeepEqual(all, tr.RangeAll()) {
+		t.Fatal("EntriesBatched concatenated differs from RangeAll")
+	}
+	for range New(intCmp).EntriesBatched(3) {
+		t.Fatal("EntriesBatched of an empty tree sent a batch")
+	}
+
+	// The producer stops and closes the channel once ctx is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := tr.EntriesBatchedCtx(ctx, 1)
+	<-ch
+	cancel()
+	n := 1
+	for range ch {
+		n++
+	}
+	if n >= tr.Len() {
+		t.Fatalf("EntriesBatchedCtx canceled after a batch sent %d batches", n)
+	}
+}
+
+func TestFromMap(t *testing.T) {
+	m := make(map[interface{}]interface{})
+	var keys []interface{}
+	for i := 0; i < 1000; i++ {
+		m[i*7919%10007] = i
+	}
+	for i := 0; i < 10007; i++ {
+		if _, ok := m[i]; ok {
+			keys = append(keys, i)
+		}
+	}
+	tr := FromMap(intCmp, m)
+	mustSizes(t, tr)
+	if !reflect.DeepEqual(tr.Keys(), keys) {
+		t.Fatal("FromMap got unsorted or other keys")
+	}
+	for k, v := range m {
+		if got, ok := tr.Get(k); !ok || got != v {
+			t.Fatalf("Get(%v) got %v %v, want %v", k, got, ok, v)
+		}
+	}
+	if n := FromMap(intCmp, nil).Len(); n != 0 {
+		t.Fatalf("FromMap of nil got Len %d", n)
+	}
+}
+
+func TestToMap(t *testing.T) {
+	if m := New(intCmp).ToMap(); m == nil || len(m) != 0 {
+		t.Fatalf("ToMap of an empty tree got %#v, want a non-nil empty map", m)
+	}
+	r := rand.New(rand.NewSource(24))
+	tr := New(intCmp)
+	for i := 0; i < 300; i++ {
+		tr.Put(r.Intn(1000), r.Int())
+	}
+	m := tr.ToMap()
+	if len(m) != tr.Len() {
+		t.Fatalf("ToMap got %d entries, want %d", len(m), tr.Len())
+	}
+	if back := FromMap(intCmp, m); !reflect.DeepEqual(back.RangeAll(), tr.RangeAll()) {
+		t.Fatal("FromMap of ToMap differs from the tree")
+	}
+}
+
+func TestPutKeepReplaceKey(t *testing.T) {
+	type id struct{ v int }
+	tr := New(func(a, b interface{}) int { return intCmp(a.(*id).v, b.(*id).v) })
+	k1, k2, k3 := &id{1}, &id{1}, &id{1} // equal by cmp, distinct instances
+	tr.Put(k1, "a")
+	tr.PutKeepKey(k2, "b")
+	if k, v := tr.Min(); k != k1 || v != "b" {
+		t.Fatalf("after PutKeepKey got key %p value %v, want key %p value b", k, v, k1)
+	}
+	tr.PutReplaceKey(k3, "c")
+	if k, v := tr.Min(); k != k3 || v != "c" {
+		t.Fatalf("after PutReplaceKey got key %p value %v, want key %p value c", k, v, k3)
+	}
+	if tr.Len() != 1 {
+		t.Fatalf("Len got %d, want 1", tr.Len())
+	}
+}
+
+func TestMinMaxValue(t *testing.T) {
+	tr := New(intCmp)
+	if k, v, ok := tr.MinValue(intCmp); ok {
+		t.Fatalf("MinValue of an empty tree got %v %v", k, v)
+	}
+	// The min value isn't at the min key, and the ties go to the min key.
+	for k, v := range []int{5, 1, 9, 1, 9, 3} {
+		tr.Put(k, v)
+	}
+	if k, v, ok := tr.MinValue(intCmp); !ok || k != 1 || v != 1 {
+		t.Fatalf("MinValue got %v %v %v, want 1 1", k, v, ok)
+	}
+	if k, v, ok := tr.MaxValue(intCmp); !ok || k != 2 || v != 9 {
+		t.Fatalf("MaxValue got %v %v %v, want 2 9", k, v, ok)
+	}
+}
+
+func TestNewWithSentinel(t *testing.T) {
+	sentinel := new(int)
+	tr := NewWithSentinel(nilFirstCmp, sentinel)
+	if k, _ := tr.Min(); k != sentinel {
+		t.Fatalf("Min of an empty tree got %v, want the sentinel", k)
+	}
+	if k, _ := tr.PopMax(); k != sentinel || tr.Len() != 0 {
+		t.Fatalf("PopMax of an empty tree got %v, want the sentinel", k)
+	}
+
+	// A nil key round-trips and isn't confused with the sentinel.
+	tr.Put(nil, "nil")
+	tr.Put(3, "c")
+	if v, ok := tr.Get(nil); !ok || v != "nil" {
+		t.Fatalf("Get(nil) got %v %v", v, ok)
+	}
+	if k, v := tr.Min(); k != nil || v != "nil" {
+		t.Fatalf("Min got %v %v, want the nil key", k, v)
+	}
+	if k, v := tr.PopMin(); k != nil || v != "nil" {
+		t.Fatalf("PopMin got %v %v, want the nil key", k, v)
+	}
+	if _, ok := tr.Get(nil); ok {
+		t.Fatal("Get(nil) after PopMin got true")
+	}
+	tr.PopMin()
+	if k, _ := tr.PopMin(); k != sentinel {
+		t.Fatalf("PopMin of an emptied tree got %v, want the sentinel", k)
+	}
+	mustValid(t, tr)
+}
+
+func TestKeysOf(t *testing.T) {
+	tr := New(intCmp)
+	for i := 20; i >= 0; i-- {
+		tr.Put(i, i%3)
+	}
+	eq := func(a, b interface{}) bool { return a == b }
+	if got, want := tr.KeysOf(1, eq), []interface{}{1, 4, 7, 10, 13, 16, 19}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("KeysOf(1) got %v, want %v", got, want)
+	}
+	if got := tr.KeysOf(5, eq); len(got) != 0 {
+		t.Fatalf("KeysOf(5) got %v", got)
+	}
+}
+
+func TestSelectRange(t *testing.T) {
+	tr := New(intCmp)
+	for i := 99; i >= 0; i-- {
+		tr.Put(i*3, i)
+	}
+	keys := tr.Keys()
+	got := tr.SelectRange(10, 20)
+	if len(got) != 10 {
+		t.Fatalf("SelectRange(10, 20) got %d key-values", len(got))
+	}
+	for i := range got {
+		if got[i].First != keys[10+i] {
+			t.Fatalf("SelectRange(10, 20)[%d] got %v, want %v", i, got[i].First, keys[10+i])
+		}
+	}
+	// The indexes are clipped to [0, Len).
+	for _, c := range [][3]int{{-5, 3, 3}, {95, 500, 5}, {5, 5, 0}, {20, 10, 0}} {
+		if n := len(tr.SelectRange(c[0], c[1])); n != c[2] {
+			t.Fatalf("SelectRange(%d, %d) got %d key-values, want %d", c[0], c[1], n, c[2])
+		}
+	}
+}
+
+func TestIsOrdered(t *testing.T) {
+	tr := New(intCmp)
+	for i := 0; i < 50; i++ {
+		tr.Put(i, i)
+	}
+	reverse := func(a, b interface{}) int { return intCmp(b, a) }
+	if !tr.IsOrdered(intCmp) {
+		t.Fatal("IsOrdered by its own cmp got false")
+	}
+	if tr.IsOrdered(reverse) {
+		t.Fatal("IsOrdered by the reverse cmp got true")
+	}
+	// Fewer than 2 keys are ordered by any cmp.
+	if !New(intCmp).IsOrdered(reverse) {
+		t.Fatal("IsOrdered of an empty tree got false")
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base, delta := New(intCmp), New(intCmp)
+	for i := 0; i < 100; i += 2 {
+		base.Put(i, i)
+	}
+	for i := 0; i < 150; i += 3 {
+		delta.Put(i, 1)
+	}
+	version := base.version
+	base.ApplyDelta(delta, func(b, d interface{}) interface{} {
+		if b == nil {
+			return d
+		}
+		return b.(int) + d.(int)
+	})
+	mustSizes(t, base)
+	for i := 0; i < 150; i++ {
+		want, inBase, inDelta := 0, i%2 == 0 && i < 100, i%3 == 0
+		if inBase {
+			want = i
+		}
+		if inDelta {
+			want++ // the increment is added to a shared key, or inserted as is
+		}
+		if v, ok := base.Get(i); ok != (inBase || inDelta) || (ok && v != want) {
+			t.Fatalf("Get(%d) got %v %v, want %d", i, v, ok, want)
+		}
+	}
+	if n := len(base.ChangedSince(version)); n != delta.Len() {
+		t.Fatalf("ApplyDelta changed %d keys, want %d", n, delta.Len())
+	}
+}
+
+func TestRangeMap(t *testing.T) {
+	tr := New(intCmp)
+	for i := 0; i < 300; i++ {
+		tr.Put(i*17%300, i)
+	}
+	project := func(key, value interface{}) interface{} {
+		return fmt.Sprint(key, "=", value)
+	}
+	for _, r := range [][2]int{{10, 50}, {-5, 3}, {299, 400}, {50, 10}} {
+		pairs := tr.Range(r[0], r[1])
+		got := tr.RangeMap(r[0], r[1], project)
+		if len(got) != len(pairs) {
+			t.Fatalf("RangeMap(%d, %d) got %d results, Range %d", r[0], r[1], len(got), len(pairs))
+		}
+		for i := range pairs {
+			if want := project(pairs[i].First, pairs[i].Second); got[i] != want {
+				t.Fatalf("RangeMap(%d, %d)[%d] got %v, want %v", r[0], r[1], i, got[i], want)
+			}
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	base, other := New(intCmp), New(intCmp)
+	for i := 0; i < 20; i++ {
+		base.Put(i, i*10)
+		other.Put(i, i*10)
+	}
+	other.Delete(3)
+	other.Delete(19)
+	other.Put(25, 250)
+	other.Put(-1, -10)
+	other.Put(7, 71)
+	eq := func(a, b interface{}) bool { return a == b }
+	added, removed, changed := base.Diff(other, eq)
+	if want := intPairs(-1, 25); !reflect.DeepEqual(added, want) {
+		t.Fatalf("Diff added got %v, want %v", added, want)
+	}
+	if want := intPairs(3, 19); !reflect.DeepEqual(removed, want) {
+		t.Fatalf("Diff removed got %v, want %v", removed, want)
+	}
+	if want := []pair.Pair{{First: 7, Second: 71}}; !reflect.DeepEqual(changed, want) {
+		t.Fatalf("Diff changed got %v, want %v", changed, want)
+	}
+	if added, removed, changed := base.Diff(base, eq); len(added)+len(removed)+len(changed) != 0 {
+		t.Fatalf("Diff of itself got %v %v %v", added, removed, changed)
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	tr := New(intCmp)
+	for i := 0; i < 100; i++ {
+		tr.Put(i, i*10)
+	}
+	a := tr.Shuffle(rand.New(rand.NewSource(1)))
+	if b := tr.Shuffle(rand.New(rand.NewSource(1))); !reflect.DeepEqual(a, b) {
+		t.Fatal("Shuffle with the same seed got different orders")
+	}
+	if reflect.DeepEqual(a, tr.RangeAll()) {
+		t.Fatal("Shuffle got the sorted order")
+	}
+	for _, res := range [][]pair.Pair{a, tr.Shuffle(nil)} {
+		back := New(intCmp)
+		for _, p := range res {
+			back.Put(p.First, p.Second)
+		}
+		if len(res) != tr.Len() || !reflect.DeepEqual(back.RangeAll(), tr.RangeAll()) {
+			t.Fatalf("Shuffle got %v, not the key-values of the tree", res)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	sorted := New(intCmp)
+	for i := 0; i < 1000; i++ {
+		sorted.Put(i, nil)
+	}
+	// Each key is put as the right child of the max, so the fixups only rotate left.
+	m := sorted.Metrics()
+	if m.LeftRotations == 0 || m.RightRotations != 0 || m.InsertFixups == 0 || m.DeleteFixups != 0 {
+		t.Fatalf("Metrics of sorted Puts got %+v", m)
+	}
+	random := New(intCmp)
+	for _, k := range rand.New(rand.NewSource(1)).Perm(1000) {
+		random.Put(k, nil)
+	}
+	if rm := random.Metrics(); rm == m || rm.RightRotations == 0 {
+		t.Fatalf("Metrics of random Puts got %+v, sorted %+v", rm, m)
+	}
+	for i := 0; i < 1000; i += 2 {
+		random.Delete(i)
+	}
+	if random.Metrics().DeleteFixups == 0 {
+		t.Fatalf("Metrics after Deletes got %+v", random.Metrics())
+	}
+	random.ResetMetrics()
+	if random.Metrics() != (Metrics{}) {
+		t.Fatalf("Metrics after ResetMetrics got %+v", random.Metrics())
+	}
+}
+
+func TestWindow(t *testing.T) {
+	tr := New(intCmp)
+	for i := 0; i < 20; i++ {
+		tr.Put(i*10, i)
+	}
+	for _, c := range []struct {
+		center, before, after int
+		want                  []interface{}
+	}{
+		{center: 50, before: 2, after: 3, want: []interface{}{30, 40, 50, 60, 70}}, // present, 50 is after
+		{center: 55, before: 2, after: 2, want: []interface{}{40, 50, 60, 70}},     // absent, split at 60
+		{center: -5, before: 3, after: 2, want: []interface{}{0, 10}},
+		{center: 500, before: 2, after: 3, want: []interface{}{180, 190}},
+		{center: 10, before: 5, after: 0, want: []interface{}{0}},
+	} {
+		var got []interface{}
+		for _, p := range tr.Window(c.center, c.before, c.after) {
+			got = append(got, p.First)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("Window(%d, %d, %d) got %v, want %v", c.center, c.before, c.after, got, c.want)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	tr := New(intCmp)
+	if k, _, ok := tr.Clamp(1); ok {
+		t.Fatalf("Clamp of an empty tree got %v", k)
+	}
+	if k, _, ok := tr.ClampFloor(1); ok {
+		t.Fatalf("ClampFloor of an empty tree got %v", k)
+	}
+	for _, k := range []int{10, 20, 30} {
+		tr.Put(k, k*10)
+	}
+	// key, Clamp, ClampFloor: below the range, in the range and above the range.
+	for _, c := range [][3]int{{1, 10, 10}, {10, 10, 10}, {15, 20, 10}, {20, 20, 20}, {25, 30, 20}, {30, 30, 30}, {99, 30, 30}} {
+		if k, v, ok := tr.Clamp(c[0]); !ok || k != c[1] || v != c[1]*10 {
+			t.Fatalf("Clamp(%d) got %v %v %v, want %d", c[0], k, v, ok, c[1])
+		}
+		if k, v, ok := tr.ClampFloor(c[0]); !ok || k != c[2] || v != c[2]*10 {
+			t.Fatalf("ClampFloor(%d) got %v %v %v, want %d", c[0], k, v, ok, c[2])
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	asFloat := func(v interface{}) float64 { return float64(v.(int)) }
+	tr := New(intCmp)
+	if y, ok := tr.InterpolateClamped(1, asFloat, asFloat); ok {
+		t.Fatalf("InterpolateClamped of an empty tree got %v", y)
+	}
+	tr.Put(10, 100)
+	tr.Put(20, 300)
+	tr.Put(40, 0)
+	for _, c := range []struct {
+		x, y float64
+		ok   bool
+	}{
+		{x: 15, y: 200, ok: true}, // midway between 10 and 20
+		{x: 30, y: 150, ok: true}, // midway between 20 and 40, decreasing
+		{x: 10, y: 100, ok: true},
+		{x: 40, y: 0, ok: true},
+		{x: 5}, {x: 41},
+	} {
+		if y, ok := tr.Interpolate(c.x, asFloat, asFloat); y != c.y || ok != c.ok {
+			t.Fatalf("Interpolate(%v) got %v %v, want %v %v", c.x, y, ok, c.y, c.ok)
+		}
+	}
+	for x, want := range map[float64]float64{5: 100, 12.5: 150, 99: 0} {
+		if y, ok := tr.InterpolateClamped(x, asFloat, asFloat); !ok || y != want {
+			t.Fatalf("InterpolateClamped(%v) got %v %v, want %v", x, y, ok, want)
+		}
+	}
+}
+
+func TestCompatibleCmp(t *testing.T) {
+	desc := func(a, b interface{}) int { return intCmp(b, a) }
+	a, b, d := New(intCmp), New(intCmp), New(desc)
+	if !a.CompatibleCmp(d, 10) {
+		t.Fatal("CompatibleCmp of empty trees got false")
+	}
+	for i := 0; i < 100; i++ {
+		a.Put(i, nil)
+		b.Put(i*7, nil)
+		d.Put(i*5, nil)
+	}
+	if !a.CompatibleCmp(b, 10) || !b.CompatibleCmp(a, 1000) {
+		t.Fatal("CompatibleCmp of two ASC trees got false")
+	}
+	if a.CompatibleCmp(d, 10) || d.CompatibleCmp(a, 3) {
+		t.Fatal("CompatibleCmp of an ASC and a DESC tree got true")
+	}
+	if !a.CompatibleCmp(d, 0) {
+		t.Fatal("CompatibleCmp without samples got false")
+	}
+}
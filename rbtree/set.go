@@ -0,0 +1,85 @@
+package rbtree
+
+// Set is an ordered set of keys, it is a rbTree without values.
+type Set struct {
+	t *rbTree
+}
+
+func NewSet(f CmpFunc) *Set {
+	return &Set{t: New(f)}
+}
+
+func (s *Set) Len() int {
+	return s.t.Len()
+}
+
+// Add adds key to the set, it does nothing if key is already in the set.
+// O(logN)
+func (s *Set) Add(key interface{}) {
+	if s.t.search(key) == s.t.nil {
+		s.t.put(key, nil)
+	}
+}
+
+// Contains returns whether key is in the set.
+// O(logN)
+func (s *Set) Contains(key interface{}) bool {
+	return s.t.search(key) != s.t.nil
+}
+
+// Remove removes key from the set, it does nothing if key is not in the set.
+// O(logN)
+func (s *Set) Remove(key interface{}) {
+	s.t.Delete(key)
+}
+
+// Min returns the minimum key, ok is false if the set is empty.
+// O(logN)
+func (s *Set) Min() (key interface{}, ok bool) {
+	key, _, ok = s.t.MinOk()
+	return key, ok
+}
+
+// Max returns the maximum key, ok is false if the set is empty.
+// O(logN)
+func (s *Set) Max() (key interface{}, ok bool) {
+	key, _, ok = s.t.MaxOk()
+	return key, ok
+}
+
+// Keys traversals in ASC
+// O(N)
+func (s *Set) Keys() []interface{} {
+	return s.t.Keys()
+}
+
+// Range returns the keys in [minKey, maxKey] in ASC.
+// O(N)
+func (s *Set) Range(minKey, maxKey interface{}) []interface{} {
+	var res []interface{}
+	s.t.walkRange(s.t.root, minKey, maxKey, func(n *node) {
+		res = append(res, n.key)
+	})
+	return res
+}
+
+// Union returns a new Set with the keys in s or other.
+// s and other must have the same cmp.
+// O(N+M)
+func (s *Set) Union(other *Set) *Set {
+	return &Set{t: s.t.Union(other.t)}
+}
+
+// Intersect returns a new Set with the keys in both s and other.
+// s and other must have the same cmp.
+// O(N+M)
+func (s *Set) Intersect(other *Set) *Set {
+	return &Set{t: s.t.Intersect(other.t)}
+}
+
+// Difference returns a new Set with the keys in s but not in other.
+// s and other must have the same cmp.
+// O(N+M)
+func (s *Set) Difference(other *Set) *Set {
+	return &Set{t: s.t.Difference(other.t)}
+}
@@ -0,0 +1,161 @@
+package rbtree
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// treeOf returns a tree of keys, the value of a key is tag.
+func treeOf(tag string, keys []int) *rbTree {
+	tr := New(intCmp)
+	for _, k := range keys {
+		tr.Put(k, tag)
+	}
+	return tr
+}
+
+// intKeys returns the keys of tr as ints.
+func intKeys(tr *rbTree) []int {
+	res := []int{}
+	for _, k := range tr.Keys() {
+		res = append(res, k.(int))
+	}
+	return res
+}
+
+func TestIntersect(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		a, b := treeOf("a", r.Perm(100)[:r.Intn(100)]), treeOf("b", r.Perm(100)[:r.Intn(100)])
+		want := []int{}
+		ka, kb := intKeys(a), intKeys(b)
+		for i, j := 0, 0; i < len(ka) && j < len(kb); {
+			if ka[i] < kb[j] {
+				i++
+			} else if ka[i] > kb[j] {
+				j++
+			} else {
+				want = append(want, ka[i])
+				i, j = i+1, j+1
+			}
+		}
+
+		res := a.Intersect(b)
+		mustValid(t, res)
+		if got := intKeys(res); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Intersect got %v, want %v", got, want)
+		}
+		for _, v := range res.Values() {
+			if v != "a" {
+				t.Fatalf("Intersect took a value %v not from the receiver", v)
+			}
+		}
+	}
+}
+
+func TestUnionDifference(t *testing.T) {
+	for name, c := range map[string]struct{ a, b []int }{
+		"empty":       {nil, nil},
+		"empty a":     {nil, []int{1, 2}},
+		"empty b":     {[]int{1, 2}, nil},
+		"disjoint":    {[]int{1, 2, 3}, []int{4, 5, 6}},
+		"identical":   {[]int{1, 2, 3}, []int{1, 2, 3}},
+		"subset":      {[]int{2, 3}, []int{1, 2, 3, 4}},
+		"superset":    {[]int{1, 2, 3, 4}, []int{2, 3}},
+		"interleaved": {[]int{1, 3, 5, 7, 9}, []int{2, 3, 4, 7, 10}},
+		"overlapped":  {[]int{1, 2, 3, 4, 5}, []int{4, 5, 6, 7}},
+	} {
+		a, b := treeOf("a", c.a), treeOf("b", c.b)
+		ka, kb := intKeys(a), intKeys(b)
+		union, diff := []int{}, []int{}
+		i, j := 0, 0
+		for i < len(ka) || j < len(kb) {
+			switch {
+			case j == len(kb) || (i < len(ka) && ka[i] < kb[j]):
+				union = append(union, ka[i])
+				diff = append(diff, ka[i])
+				i++
+			case i == len(ka) || ka[i] > kb[j]:
+				union = append(union, kb[j])
+				j++
+			default:
+				union = append(union, ka[i])
+				i, j = i+1, j+1
+			}
+		}
+
+		u, d := a.Union(b), a.Difference(b)
+		mustValid(t, u)
+		mustValid(t, d)
+		if got := intKeys(u); !reflect.DeepEqual(got, union) {
+			t.Fatalf("%s: Union got %v, want %v", name, got, union)
+		}
+		if got := intKeys(d); !reflect.DeepEqual(got, diff) {
+			t.Fatalf("%s: Difference got %v, want %v", name, got, diff)
+		}
+		for _, p := range u.RangeAll() {
+			if _, inA := a.Get(p.First); inA != (p.Second == "a") {
+				t.Fatalf("%s: Union took %v for key %v, the receiver must win", name, p.Second, p.First)
+			}
+		}
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	randSet := func() (*Set, map[int]bool) {
+		s, m := NewSet(intCmp), make(map[int]bool)
+		for i := r.Intn(60); i > 0; i-- {
+			k := r.Intn(80)
+			s.Add(k)
+			m[k] = true
+			if r.Intn(5) == 0 {
+				k = r.Intn(80)
+				s.Remove(k)
+				delete(m, k)
+			}
+		}
+		return s, m
+	}
+	// mustEqual fails t if s doesn't hold exactly the keys of m, in ASC.
+	mustEqual := func(op string, s *Set, m map[int]bool) {
+		t.Helper()
+		want := make([]interface{}, 0, len(m))
+		for k := 0; k < 80; k++ {
+			if m[k] {
+				want = append(want, k)
+			}
+		}
+		if got := s.Keys(); s.Len() != len(m) || !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s got %v, want %v", op, got, want)
+		}
+		for k := 0; k < 80; k++ {
+			if s.Contains(k) != m[k] {
+				t.Fatalf("%s: Contains(%d) got %v", op, k, !m[k])
+			}
+		}
+		mustValid(t, s.t)
+	}
+
+	for round := 0; round < 100; round++ {
+		a, ma := randSet()
+		b, mb := randSet()
+		mustEqual("Add and Remove", a, ma)
+		union, inter, diff := make(map[int]bool), make(map[int]bool), make(map[int]bool)
+		for k := range ma {
+			union[k] = true
+			if mb[k] {
+				inter[k] = true
+			} else {
+				diff[k] = true
+			}
+		}
+		for k := range mb {
+			union[k] = true
+		}
+		mustEqual("Union", a.Union(b), union)
+		mustEqual("Intersect", a.Intersect(b), inter)
+		mustEqual("Difference", a.Difference(b), diff)
+	}
+}
@@ -0,0 +1,119 @@
+package rbtree
+
+import (
+	"container/heap"
+	"github.com/shengmingzhu/datastructures/pair"
+)
+
+// ShardedTree is composed of independent SyncTrees, keys are routed to the shards by a user-supplied function,
+// so that writes to different shards don't contend for one lock. It is safe for concurrent use.
+type ShardedTree struct {
+	shards []*SyncTree
+	shard  func(key interface{}) int
+	cmp    CmpFunc
+}
+
+// NewSharded returns a ShardedTree with n shards, key is stored in shards[shard(key) % n].
+// shard must return the same shard for keys which are the same under f.
+func NewSharded(f CmpFunc, n int, shard func(key interface{}) int) *ShardedTree {
+	if n <= 0 {
+		panic("rbtree: ShardedTree needs at least one shard")
+	}
+	s := &ShardedTree{shards: make([]*SyncTree, n), shard: shard, cmp: f}
+	for i := range s.shards {
+		s.shards[i] = NewSync(f)
+	}
+	return s
+}
+
+// Len returns the sum of the lengths of all shards.
+// O(shards)
+func (s *ShardedTree) Len() int {
+	l := 0
+	for _, t := range s.shards {
+		l += t.Len()
+	}
+	return l
+}
+
+// Get returns the value to key, or ok is false if not found.
+// O(logN)
+func (s *ShardedTree) Get(key interface{}) (value interface{}, ok bool) {
+	return s.route(key).Get(key)
+}
+
+// Put stores the key-value pair into the shard of key.
+// O(logN)
+func (s *ShardedTree) Put(key interface{}, value interface{}) {
+	s.route(key).Put(key, value)
+}
+
+// O(logN)
+func (s *ShardedTree) Delete(key interface{}) {
+	s.route(key).Delete(key)
+}
+
+// Range traversals in [minKey, maxKey] in ASC across all shards.
+// Each shard is ranged separately, so the result is not a snapshot of all shards at one moment.
+// Pair.First: Key, Pair.Second: Value
+// O(Nlog(shards))
+func (s *ShardedTree) Range(minKey, maxKey interface{}) []pair.Pair {
+	h := &pairsHeap{cmp: s.cmp}
+	total := 0
+	for _, t := range s.shards {
+		if res := t.Range(minKey, maxKey); len(res) > 0 {
+			h.lists = append(h.lists, res)
+			total += len(res)
+		}
+	}
+	heap.Init(h)
+
+	res := make([]pair.Pair, 0, total)
+	for h.Len() > 0 {
+		list := h.lists[0]
+		res = append(res, list[0])
+		if len(list) > 1 {
+			h.lists[0] = list[1:]
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return res
+}
+
+func (s *ShardedTree) route(key interface{}) *SyncTree {
+	i := s.shard(key) % len(s.shards)
+	if i < 0 {
+		i += len(s.shards)
+	}
+	return s.shards[i]
+}
+
+// pairsHeap is a min-heap of sorted pair lists, ordered by their first keys.
+type pairsHeap struct {
+	lists [][]pair.Pair
+	cmp   CmpFunc
+}
+
+func (h *pairsHeap) Len() int {
+	return len(h.lists)
+}
+
+func (h *pairsHeap) Less(i, j int) bool {
+	return h.cmp(h.lists[i][0].First, h.lists[j][0].First) < 0
+}
+
+func (h *pairsHeap) Swap(i, j int) {
+	h.lists[i], h.lists[j] = h.lists[j], h.lists[i]
+}
+
+func (h *pairsHeap) Push(x interface{}) {
+	h.lists = append(h.lists, x.([]pair.Pair))
+}
+
+func (h *pairsHeap) Pop() interface{} {
+	last := h.lists[len(h.lists)-1]
+	h.lists = h.lists[:len(h.lists)-1]
+	return last
+}
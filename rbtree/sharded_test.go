@@ -0,0 +1,80 @@
+package rbtree
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestShardedTree(t *testing.T) {
+	const shards = 4
+	s := NewSharded(intCmp, shards, func(key interface{}) int { return key.(int) })
+	ref := New(intCmp)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		k := r.Intn(1000) - 500 // negative keys are routed by the non-negative remainder
+		if r.Intn(4) == 0 {
+			s.Delete(k)
+			ref.Delete(k)
+		} else {
+			s.Put(k, i)
+			ref.Put(k, i)
+		}
+	}
+	if s.Len() != ref.Len() {
+		t.Fatalf("Len got %d, want %d", s.Len(), ref.Len())
+	}
+	// Each key is in its own shard only.
+	for i, shard := range s.shards {
+		for _, p := range shard.RangeAll() {
+			if k := p.First.(int); ((k%shards)+shards)%shards != i {
+				t.Fatalf("key %d is in shard %d", k, i)
+			}
+		}
+		if shard.Len() == 0 {
+			t.Fatalf("shard %d is empty", i)
+		}
+	}
+	for _, w := range [][2]int{{-500, 500}, {-10, 10}, {3, 3}, {100, 50}, {600, 700}} {
+		if got, want := s.Range(w[0], w[1]), ref.Range(w[0], w[1]); len(got) != len(want) || (len(got) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Fatalf("Range(%d, %d) got %v, want %v", w[0], w[1], got, want)
+		}
+	}
+	for k := -500; k < 500; k++ {
+		got, ok := s.Get(k)
+		want, wantOk := ref.Get(k)
+		if got != want || ok != wantOk {
+			t.Fatalf("Get(%d) got %v %v, want %v %v", k, got, ok, want, wantOk)
+		}
+	}
+}
+
+// TestShardedTreeConcurrent writes and ranges from several goroutines, run it with -race.
+func TestShardedTreeConcurrent(t *testing.T) {
+	s := NewSharded(intCmp, 8, func(key interface{}) int { return key.(int) })
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				s.Put(w*1000+i, i)
+				if i%3 == 0 {
+					s.Delete(w*1000 + i/2)
+				}
+				res := s.Range(0, 4000)
+				for j := 1; j < len(res); j++ {
+					if intCmp(res[j-1].First, res[j].First) >= 0 {
+						t.Errorf("Range is not in ASC at %d", j)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if got := len(s.Range(0, 4000)); got != s.Len() {
+		t.Fatalf("Range got %d key-values, Len %d", got, s.Len())
+	}
+}
@@ -0,0 +1,30 @@
+package rbtree
+
+import (
+	"github.com/shengmingzhu/datastructures/pair"
+	"strings"
+)
+
+// StringTree is a rbTree whose keys are strings in ASC by strings.Compare, which supports PrefixRange.
+type StringTree struct {
+	*rbTree
+}
+
+// NewStringTree returns an empty StringTree, all keys put into it must be strings.
+func NewStringTree() *StringTree {
+	return &StringTree{rbTree: New(func(key1, key2 interface{}) int {
+		return strings.Compare(key1.(string), key2.(string))
+	})}
+}
+
+// PrefixRange returns the key-values whose keys begin with prefix in ASC.
+// They are adjacent in the tree, the walk starts from the ceiling of prefix and stops at the first key without prefix.
+// Pair.First: Key, Pair.Second: Value
+// O(logN + M), M is the count of matches
+func (t *StringTree) PrefixRange(prefix string) []pair.Pair {
+	var res []pair.Pair
+	for n := t.ceiling(prefix); n != t.nil && strings.HasPrefix(n.key.(string), prefix); n = t.successor(n) {
+		res = append(res, pair.Pair{First: n.key, Second: n.value})
+	}
+	return res
+}
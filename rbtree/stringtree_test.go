@@ -0,0 +1,27 @@
+package rbtree
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrefixRange(t *testing.T) {
+	tr := NewStringTree()
+	for _, s := range []string{"banana", "apply", "ap", "apple", "b", "a", "ap\U0010FFFFz", "aq"} {
+		tr.Put(s, len(s))
+	}
+	var got []interface{}
+	for _, p := range tr.PrefixRange("ap") {
+		got = append(got, p.First)
+	}
+	// A key with the max rune right after the prefix still begins with it.
+	if want := []interface{}{"ap", "apple", "apply", "ap\U0010FFFFz"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("PrefixRange(ap) got %q, want %q", got, want)
+	}
+	if n := len(tr.PrefixRange("")); n != tr.Len() {
+		t.Fatalf("PrefixRange of the empty prefix got %d key-values, want %d", n, tr.Len())
+	}
+	if got := tr.PrefixRange("c"); len(got) != 0 {
+		t.Fatalf("PrefixRange(c) got %v", got)
+	}
+}
@@ -0,0 +1,73 @@
+package rbtree
+
+import (
+	"github.com/shengmingzhu/datastructures/pair"
+	"sync"
+)
+
+// SyncTree is a rbTree protected by a sync.RWMutex, it is safe for concurrent use.
+type SyncTree struct {
+	mu sync.RWMutex
+	t  *rbTree
+}
+
+func NewSync(f CmpFunc) *SyncTree {
+	return &SyncTree{t: New(f)}
+}
+
+func (s *SyncTree) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Len()
+}
+
+// Get returns the value to key, or ok is false if not found.
+// O(logN)
+func (s *SyncTree) Get(key interface{}) (value interface{}, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Get(key)
+}
+
+// Put stores the key-value pair, see rbTree.Put.
+// O(logN)
+func (s *SyncTree) Put(key interface{}, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.Put(key, value)
+}
+
+// O(logN)
+func (s *SyncTree) Delete(key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.Delete(key)
+}
+
+// Range traversals in [minKey, maxKey] in ASC
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (s *SyncTree) Range(minKey, maxKey interface{}) []pair.Pair {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.Range(minKey, maxKey)
+}
+
+// RangeAll traversals in ASC
+// Pair.First: Key, Pair.Second: Value
+// O(N)
+func (s *SyncTree) RangeAll() []pair.Pair {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.t.RangeAll()
+}
+
+// ReplaceAll replaces all key-values with pairs, see rbTree.ReplaceAll.
+// The new contents are built before the lock, readers see either all the old key-values or all the new ones.
+// O(M), M = len(pairs)
+func (s *SyncTree) ReplaceAll(pairs []pair.Pair) {
+	root := s.t.buildPairs(pairs)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.swapRoot(root, len(pairs))
+}
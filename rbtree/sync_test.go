@@ -0,0 +1,48 @@
+package rbtree
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/shengmingzhu/datastructures/pair"
+)
+
+func TestSyncTreeReplaceAll(t *testing.T) {
+	var sets [2][]pair.Pair
+	for i := 0; i < 200; i++ {
+		sets[0] = append(sets[0], pair.Pair{First: i, Second: 0})
+		sets[1] = append(sets[1], pair.Pair{First: i * 2, Second: 1})
+	}
+	s := NewSync(intCmp)
+	s.ReplaceAll(sets[0])
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				got := s.RangeAll()
+				if !reflect.DeepEqual(got, sets[0]) && !reflect.DeepEqual(got, sets[1]) {
+					t.Error("a reader saw a partially replaced tree")
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 200; i++ {
+		s.ReplaceAll(sets[i%2])
+	}
+	close(stop)
+	wg.Wait()
+	if got := s.RangeAll(); !reflect.DeepEqual(got, sets[0]) {
+		t.Fatalf("got %v after the last ReplaceAll", got)
+	}
+}
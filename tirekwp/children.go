@@ -0,0 +1,122 @@
+package tirekwp
+
+// maxSmallChildren is the fan-out at which children are promoted from the slice to a hash-map.
+// Most nodes of a keyword trie have a few children, a short slice beats a map on both memory and lookup.
+const maxSmallChildren = 8
+
+type child struct {
+	r rune
+	n *node
+}
+
+// children maps a rune to the child node.
+// It starts as a slice sorted by rune, and is promoted to a hash-map when it grows past maxSmallChildren.
+// Once promoted, it stays a hash-map.
+type children struct {
+	small []child
+	big   map[rune]*node
+}
+
+func (c *children) len() int {
+	if c.big != nil {
+		return len(c.big)
+	}
+	return len(c.small)
+}
+
+func (c *children) get(r rune) (*node, bool) {
+	if c.big != nil {
+		n, ok := c.big[r]
+		return n, ok
+	}
+	for i := range c.small {
+		if c.small[i].r == r {
+			return c.small[i].n, true
+		} else if c.small[i].r > r {
+			break
+		}
+	}
+	return nil, false
+}
+
+func (c *children) set(r rune, n *node) {
+	if c.big != nil {
+		c.big[r] = n
+		return
+	}
+
+	i := 0
+	for i < len(c.small) && c.small[i].r < r {
+		i++
+	}
+	if i < len(c.small) && c.small[i].r == r {
+		c.small[i].n = n
+		return
+	}
+	if len(c.small) >= maxSmallChildren {
+		c.big = make(map[rune]*node, len(c.small)+1)
+		for _, ch := range c.small {
+			c.big[ch.r] = ch.n
+		}
+		c.big[r] = n
+		c.small = nil
+		return
+	}
+	if len(c.small) == cap(c.small) {
+		// Most nodes have 1 or 2 children, the others grow at once to the size of promotion.
+		size := 2
+		if len(c.small) >= size {
+			size = maxSmallChildren
+		}
+		small := make([]child, len(c.small), size)
+		copy(small, c.small)
+		c.small = small
+	}
+	c.small = append(c.small, child{})
+	copy(c.small[i+1:], c.small[i:])
+	c.small[i] = child{r: r, n: n}
+}
+
+func (c *children) del(r rune) {
+	if c.big != nil {
+		delete(c.big, r)
+		return
+	}
+	for i := range c.small {
+		if c.small[i].r == r {
+			c.small = append(c.small[:i], c.small[i+1:]...)
+			return
+		}
+	}
+}
+
+// each calls fn for each child until fn returns false, it returns false if stopped by fn.
+// Children in the slice are visited in ASC of rune, the order of a hash-map is random.
+func (c *children) each(fn func(r rune, n *node) bool) bool {
+	if c.big != nil {
+		for r, n := range c.big {
+			if !fn(r, n) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, ch := range c.small {
+		if !fn(ch.r, ch.n) {
+			return false
+		}
+	}
+	return true
+}
+
+// clone returns a copy of c which shares the child nodes.
+func (c *children) clone() children {
+	if c.big != nil {
+		big := make(map[rune]*node, len(c.big))
+		for r, n := range c.big {
+			big[r] = n
+		}
+		return children{big: big}
+	}
+	return children{small: append([]child(nil), c.small...)}
+}
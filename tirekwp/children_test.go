@@ -0,0 +1,136 @@
+package tirekwp
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestChildren(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, fanOut := range []int{3, maxSmallChildren, 50} {
+		var c children
+		ref := make(map[rune]*node)
+		for i := 0; i < fanOut*4; i++ {
+			ch := rune('a' + r.Intn(fanOut))
+			switch r.Intn(3) {
+			case 0, 1:
+				n := &node{}
+				c.set(ch, n)
+				ref[ch] = n
+			case 2:
+				c.del(ch)
+				delete(ref, ch)
+			}
+			if c.len() != len(ref) {
+				t.Fatalf("fan-out %d: len %d, want %d", fanOut, c.len(), len(ref))
+			}
+			for j := 0; j < fanOut; j++ {
+				ch := rune('a' + j)
+				got, ok := c.get(ch)
+				if want, wantOk := ref[ch]; got != want || ok != wantOk {
+					t.Fatalf("fan-out %d: get(%q) got %p %v, want %p %v", fanOut, ch, got, ok, want, wantOk)
+				}
+			}
+		}
+		prev := rune(-1)
+		c.each(func(r rune, n *node) bool {
+			if c.big == nil && r <= prev {
+				t.Fatalf("fan-out %d: the slice is not in ASC of rune", fanOut)
+			}
+			prev = r
+			return true
+		})
+	}
+}
+
+// benchAlphabets are a low fan-out alphabet like English words, and a high fan-out one like CJK text.
+var benchAlphabets = []struct {
+	name     string
+	alphabet []rune
+}{
+	{"LowFanOut", []rune("etaoinshrdlu")},
+	{"HighFanOut", func() []rune {
+		rs := make([]rune, 500)
+		for i := range rs {
+			rs[i] = rune(0x4e00 + i)
+		}
+		return rs
+	}()},
+}
+
+func benchKeywords(alphabet []rune, n int) []Keyword {
+	return genKeywords(rand.New(rand.NewSource(1)), n, 8, string(alphabet))
+}
+
+func BenchmarkPut(b *testing.B) {
+	for _, a := range benchAlphabets {
+		kws := benchKeywords(a.alphabet, 20000)
+		b.Run(a.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				t := New(10)
+				for _, kw := range kws {
+					t.Put(kw.Str, kw.Weight)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, a := range benchAlphabets {
+		kws := benchKeywords(a.alphabet, 20000)
+		t := New(10)
+		for _, kw := range kws {
+			t.Put(kw.Str, kw.Weight)
+		}
+		b.Run(a.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				t.Get(kws[i%len(kws)].Str)
+			}
+		})
+	}
+}
+
+// childrenSink keeps the benchmarked children on the heap, as they are in the nodes.
+var childrenSink interface{}
+
+// BenchmarkChildren compares children with a plain map, which was used before, at several fan-outs.
+func BenchmarkChildren(b *testing.B) {
+	for _, fanOut := range []int{2, 4, maxSmallChildren, 32} {
+		runes := make([]rune, fanOut)
+		for i := range runes {
+			runes[i] = rune('a' + i)
+		}
+		n := &node{}
+
+		b.Run(fmt.Sprintf("SetGet/slice-or-map/%d", fanOut), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c := &children{}
+				for _, r := range runes {
+					c.set(r, n)
+				}
+				for _, r := range runes {
+					c.get(r)
+				}
+				childrenSink = c
+			}
+		})
+		b.Run(fmt.Sprintf("SetGet/map/%d", fanOut), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m := make(map[rune]*node)
+				for _, r := range runes {
+					m[r] = n
+				}
+				for _, r := range runes {
+					_ = m[r]
+				}
+				childrenSink = m
+			}
+		})
+	}
+}
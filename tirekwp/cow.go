@@ -0,0 +1,86 @@
+package tirekwp
+
+import (
+	"github.com/shengmingzhu/orderedmap"
+	"sync"
+	"sync/atomic"
+)
+
+// cow is the state of a TireKWP in copy-on-write mode.
+// Writers are serialized by mu, they clone the nodes on the path they modify, so the published nodes are never
+// modified, then publish the new root by snap. Readers load snap without lock and read a stable snapshot.
+type cow struct {
+	mu   sync.Mutex
+	snap atomic.Value // *snapshot
+}
+
+// snapshot is a version of the trie, it must not be modified after published.
+type snapshot struct {
+	root   *node
+	len    int
+	nNodes int
+}
+
+// NewCOW returns a TireKWP in copy-on-write mode, Get and the other queries can be called concurrently with writes
+// without lock, each query reads a snapshot of the trie which is never partially updated.
+// Writes are serialized, each Put, Add or Delete clones the nodes on its path, with their sorted, O(depth*maxSortedLen).
+// The returned *Keyword of GetKWs are never modified, Add replaces the keyword with a new one.
+func NewCOW(maxSortedLen int) *TireKWP {
+	t := New(maxSortedLen)
+	t.cow = &cow{}
+	t.cow.snap.Store(&snapshot{root: t.root, len: t.len, nNodes: t.nNodes})
+	return t
+}
+
+// view returns the trie for reading, it is the latest published snapshot in copy-on-write mode.
+func (t *TireKWP) view() snapshot {
+	if t.cow != nil {
+		return *t.cow.snap.Load().(*snapshot)
+	}
+	return snapshot{root: t.root, len: t.len, nNodes: t.nNodes}
+}
+
+// begin starts a write, in copy-on-write mode it locks the writers and clones root.
+func (t *TireKWP) begin() {
+	if t.cow != nil {
+		t.cow.mu.Lock()
+		t.root = t.root.clone()
+	}
+}
+
+// commit ends a write started by begin, in copy-on-write mode it publishes the trie and unlocks the writers.
+func (t *TireKWP) commit() {
+	if t.cow != nil {
+		t.cow.snap.Store(&snapshot{root: t.root, len: t.len, nNodes: t.nNodes})
+		t.cow.mu.Unlock()
+	}
+}
+
+// next returns the child of n for r. In copy-on-write mode the child is cloned and replaces the original in n,
+// so that the caller can modify it, n must be a node cloned by the current write.
+func (t *TireKWP) next(n *node, r rune) (*node, bool) {
+	child, ok := n.next.get(r)
+	if ok && t.cow != nil {
+		child = child.clone()
+		n.next.set(r, child)
+	}
+	return child, ok
+}
+
+// clone returns a copy of n which shares the keywords and the children nodes with n.
+// If n.sorted is marked to rebuild by PutAll, the copy is marked too.
+func (n *node) clone() *node {
+	c := &node{
+		key:   n.key,
+		total: n.total,
+		count: n.count,
+		next:  n.next.clone(),
+	}
+	if n.sorted != nil {
+		c.sorted = orderedmap.NewAny(cmp)
+		for _, key := range n.sorted.Keys() {
+			c.sorted.Put(key, nil)
+		}
+	}
+	return c
+}
@@ -0,0 +1,74 @@
+package tirekwp
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// TestCOWConcurrent writes by some writers while readers query, run it with -race.
+// Each writer writes keywords starting with its own rune, so the readers can check the snapshots of the rune.
+func TestCOWConcurrent(t *testing.T) {
+	tr := NewCOW(5)
+	const writers, readers, rounds = 4, 4, 2000
+
+	done := make(chan struct{})
+	var rg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		rg.Add(1)
+		go func(i int) {
+			defer rg.Done()
+			prefix := string(rune('a' + i%writers))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				kws := tr.GetKWs(prefix)
+				for j, kw := range kws {
+					if kw.Str[:1] != prefix || (j > 0 && cmp(kws[j-1], kw) >= 0) {
+						t.Errorf("GetKWs(%q) got %q at %d, not in the order of weight", prefix, kw.Str, j)
+						return
+					}
+				}
+				if n := tr.CountPrefix(prefix); len(kws) > n {
+					t.Errorf("GetKWs(%q) got %d keywords, CountPrefix %d", prefix, len(kws), n)
+					return
+				}
+				tr.Best(prefix)
+				tr.Len()
+			}
+		}(i)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			prefix := string(rune('a' + w))
+			for i := 0; i < rounds; i++ {
+				kw := prefix + genKeywords(r, 1, 4, "xyz")[0].Str
+				switch r.Intn(5) {
+				case 0, 1:
+					tr.Put(kw, r.Intn(100))
+				case 2:
+					tr.Add(kw, 1)
+				case 3:
+					tr.Delete(kw)
+				case 4:
+					tr.PutAll([]Keyword{{Str: kw, Weight: 5}, {Str: kw + "x", Weight: 7}})
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(done)
+	rg.Wait()
+	if err := tr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
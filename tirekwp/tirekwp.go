@@ -6,15 +6,22 @@ package tirekwp
 import (
 	"fmt"
 	"github.com/shengmingzhu/orderedmap"
+	"golang.org/x/text/unicode/norm"
+	"sort"
 	"strings"
+	"unicode"
 	"unsafe"
 )
 
 type TireKWP struct {
 	root         *node
 	maxSortedLen int
-	len          int // count of keywords
-	nNodes       int // count of nodes
+	len          int                 // count of keywords
+	nNodes       int                 // count of nodes
+	fold         func(string) []rune // converts a string to the runes used as the trie path, nil means []rune(str)
+	score        func(kw *Keyword, query string) float64
+	cow          *cow // non-nil in copy-on-write mode, see NewCOW
+	deferSorted  bool // if true, put marks the sorted to rebuild instead of adjusting them, see PutAll
 }
 
 type Keyword struct {
@@ -32,137 +39,435 @@ func New(maxSortedLen int) *TireKWP {
 	}
 }
 
+// NewNormalized returns a TireKWP which matches case-insensitively and ignores diacritics,
+// so that "cafe" and "CAFÉ" both find "café". The original string is kept in Keyword.Str.
+// Keywords which are the same after normalization are treated as one keyword, the first put wins.
+func NewNormalized(maxSortedLen int) *TireKWP {
+	t := New(maxSortedLen)
+	t.fold = normalize
+	return t
+}
+
+// NewWithTransform returns a TireKWP which maps each rune by transform on Put and Get, the original string is kept in Keyword.Str.
+// For example, mapping all digits to '0' makes "abc9" find "abc1".
+// Strings colliding under transform share the same path, so their suggestions are merged,
+// and keywords which are the same after transform are treated as one keyword, the first put wins.
+func NewWithTransform(maxSortedLen int, transform func(rune) rune) *TireKWP {
+	t := New(maxSortedLen)
+	t.fold = func(str string) []rune {
+		rs := []rune(str)
+		for i := range rs {
+			rs[i] = transform(rs[i])
+		}
+		return rs
+	}
+	return t
+}
+
+// NewWithScorer returns a TireKWP whose suggestions are re-ranked by score in DESC at query time,
+// keywords with same scores keep the order of weight.
+// The candidates are still the top maxSortedLen keywords by weight, score only reorders them.
+func NewWithScorer(maxSortedLen int, score func(kw *Keyword, query string) float64) *TireKWP {
+	t := New(maxSortedLen)
+	t.score = score
+	return t
+}
+
 func newNode(key *Keyword) *node {
 	n := &node{
 		key:    key,
-		next:   make(map[rune]*node),
 		sorted: orderedmap.NewAny(cmp),
 	}
 
 	if key != nil {
 		n.sorted.Put(key, nil)
+		n.total = key.Weight
+		n.count = 1
 	}
 
 	return n
 }
 
 func (t *TireKWP) Put(str string, weight int) {
+	t.begin()
+	defer t.commit()
+	t.insert(str, weight)
+}
+
+// PutR is Put which reports what it did, inserted is false if str is already in t, then t is not changed.
+// forked is true if the insert split a leaf, which holds a longer keyword, into a branch,
+// for example, "go" and then "golf", or "golf" and then "gone".
+func (t *TireKWP) PutR(str string, weight int) (forked, inserted bool) {
+	t.begin()
+	defer t.commit()
+	return t.insert(str, weight)
+}
+
+// PutAll puts the keywords like Put one by one, the first one wins if a keyword repeats.
+// The sorted of the nodes are not adjusted for each keyword, but rebuilt once for each node the keywords pass,
+// from the bottom up at the end, which is faster for bulk loads.
+func (t *TireKWP) PutAll(kws []Keyword) {
+	keys := make([]Keyword, len(kws))
+	for i := range kws {
+		if len(kws[i].Str) <= 0 {
+			panic("Can't put an empty string to tireKWP.")
+		}
+		keys[i] = Keyword{str: t.runes(kws[i].Str), Str: kws[i].Str, Weight: kws[i].Weight}
+		if len(keys[i].str) <= 0 {
+			panic(fmt.Sprintf("We have a problem when converting string[%s] to rune.", kws[i].Str))
+		}
+	}
+
+	t.begin()
+	defer t.commit()
+	t.deferSorted = true
+	for i := range keys {
+		t.insertKey(&keys[i])
+	}
+	t.deferSorted = false
+	t.root.rebuildDeferred(&merger{}, t.maxSortedLen)
+}
+
+// insert puts the keyword str if it's not in t, see PutR for the results.
+func (t *TireKWP) insert(str string, weight int) (forked, inserted bool) {
 	if len(str) <= 0 {
 		panic("Can't put an empty string to tireKWP.")
 	}
-	key := Keyword{str: []rune(str), Str: str, Weight: weight}
+	key := Keyword{str: t.runes(str), Str: str, Weight: weight}
 	if len(key.str) <= 0 {
 		panic(fmt.Sprintf("We have a problem when converting string[%s] to rune.", str))
 	}
+	return t.insertKey(&key)
+}
 
-	n := t.get(key.str)
-	if n != nil && n.key != nil && len(n.key.Str) == len(str) {
-		return
+// insertKey puts key if it's not in t, key.str must be converted from key.Str. See PutR for the results.
+func (t *TireKWP) insertKey(key *Keyword) (forked, inserted bool) {
+	n := t.get(t.root, key.str)
+	if n != nil && n.key != nil && len(n.key.str) == len(key.str) {
+		return false, false
 	}
 
-	t.put(&key)
+	forked = t.put(key)
 	t.len++
+	return forked, true
 }
 
 // key must not in t. If not sure, must get() and delete() key first.
-func (t *TireKWP) put(key *Keyword) {
+// It returns true if a leaf is split into a branch.
+func (t *TireKWP) put(key *Keyword) (forked bool) {
 	// 1. pos = len has traversal
 	// 2. pos point to the next rune
 	pos := 0
-	now, ok := t.root.next[key.str[pos]]
+	t.root.total += key.Weight
+	t.root.count++
+	now, ok := t.next(t.root, key.str[pos])
 	if !ok {
-		t.root.next[key.str[pos]] = newNode(key)
+		t.root.next.set(key.str[pos], newNode(key))
 		t.nNodes++
-		t.root.adjustSorted(key, t.maxSortedLen)
-		return
+		t.adjust(t.root, key)
+		return false
 	}
-	t.root.adjustSorted(key, t.maxSortedLen)
+	t.adjust(t.root, key)
 
 	for {
+		now.total += key.Weight // key is in the subtree of now
+		now.count++
 		pos++
 		// Case 1: key traversal completed, store key to now.
 		if pos == len(key.str) {
 			if now.key != nil {
 				// Case 1.1: now is leaf node
-				now.next[now.key.str[pos]] = newNode(now.key)
+				now.next.set(now.key.str[pos], newNode(now.key))
 				t.nNodes++
+				forked = true
 			}
 			// store key to now
 			now.key = key
 			// key's weight may changed, so we adjust now.sorted
-			now.adjustSorted(key, t.maxSortedLen)
+			t.adjust(now, key)
 			break
 		}
 
 		// Case 2: now is leaf node
-		if len(now.next) <= 0 {
+		if now.next.len() <= 0 {
+			forked = true
 			k2 := now.key
 			// Handling same prefixes in loop
 			for pos < len(key.str) && pos < len(k2.str) && key.str[pos] == k2.str[pos] {
-				newN := newNode(nil)
+				newN := &node{total: key.Weight + k2.Weight, count: 2}
+				if !t.deferSorted { // else newN is marked below, its sorted is built by rebuildDeferred
+					newN.sorted = orderedmap.NewAny(cmp)
+				}
 				t.nNodes++
-				now.next[key.str[pos]] = newN
-				now.adjustSorted(key, t.maxSortedLen)
-				now.adjustSorted(k2, t.maxSortedLen)
+				now.next.set(key.str[pos], newN)
+				t.adjust(now, key)
+				t.adjust(now, k2)
 				now.key = nil
 				now = newN
 				pos++
 			}
 			if pos == len(key.str) { // Case 2.1: key traversal completed
 				now.key = key
-				now.adjustSorted(key, t.maxSortedLen)
+				t.adjust(now, key)
 
-				now.next[k2.str[pos]] = newNode(k2)
+				now.next.set(k2.str[pos], newNode(k2))
 				t.nNodes++
-				now.adjustSorted(k2, t.maxSortedLen)
+				t.adjust(now, k2)
 			} else if pos == len(k2.str) { // Case 2.2: k2 traversal completed
 				now.key = k2
-				now.adjustSorted(k2, t.maxSortedLen)
+				t.adjust(now, k2)
 
-				now.next[key.str[pos]] = newNode(key)
+				now.next.set(key.str[pos], newNode(key))
 				t.nNodes++
-				now.adjustSorted(key, t.maxSortedLen)
+				t.adjust(now, key)
 			} else { // Case 2.3: fork
 				now.key = nil
-				now.adjustSorted(key, t.maxSortedLen)
-				now.adjustSorted(k2, t.maxSortedLen)
+				t.adjust(now, key)
+				t.adjust(now, k2)
 
-				now.next[key.str[pos]] = newNode(key)
+				now.next.set(key.str[pos], newNode(key))
 				t.nNodes++
-				now.adjustSorted(key, t.maxSortedLen)
-				now.next[k2.str[pos]] = newNode(k2)
+				t.adjust(now, key)
+				now.next.set(k2.str[pos], newNode(k2))
 				t.nNodes++
-				now.adjustSorted(k2, t.maxSortedLen)
+				t.adjust(now, k2)
 			}
 			break
 		}
 
 		// Case 3: now is not a leaf node
-		next, ok := now.next[key.str[pos]]
+		next, ok := t.next(now, key.str[pos])
 		if !ok {
-			now.next[key.str[pos]] = newNode(key)
+			now.next.set(key.str[pos], newNode(key))
 			t.nNodes++
-			now.adjustSorted(key, t.maxSortedLen)
+			t.adjust(now, key)
 			break
 		}
 
-		now.adjustSorted(key, t.maxSortedLen)
+		t.adjust(now, key)
 		now = next
 	}
+	return forked
 }
 
-func (t *TireKWP) Get(str string) []string {
-	var keys []interface{}
-	if str == "" {
-		keys = t.root.sorted.Keys()
-	} else {
-		r := []rune(str)
-		n := t.get(r)
-		if n != nil {
-			keys = n.sorted.Keys()
+// Add adds delta to the weight of keyword str, if str is new, it is put with weight delta.
+// It's useful to count term frequency.
+func (t *TireKWP) Add(str string, delta int) {
+	t.begin()
+	defer t.commit()
+	if path := t.find(t.runes(str)); path != nil {
+		t.reweight(path, path[len(path)-1].key.Weight+delta)
+		return
+	}
+	t.insert(str, delta)
+}
+
+// reweight changes the weight of the key of the last node of path, and adjusts the sorted of the nodes on path.
+func (t *TireKWP) reweight(path []*node, weight int) {
+	key := path[len(path)-1].key
+	if weight == key.Weight {
+		return
+	}
+	old := key
+	if t.cow != nil {
+		// The published snapshots may hold key, so it is replaced by a copy instead of modified.
+		cp := *key
+		key = &cp
+		path[len(path)-1].key = key
+	}
+
+	// key must be removed from sorted before its weight changes, or it can't be found by cmp.
+	ranked := make([]bool, len(path))
+	for i := range path {
+		if _, ok := path[i].sorted.Get(old); ok {
+			path[i].sorted.Delete(old)
+			ranked[i] = true
 		}
 	}
 
+	increased := weight > key.Weight
+	for i := range path {
+		path[i].total += weight - key.Weight
+	}
+	key.Weight = weight
+	for i := len(path) - 1; i >= 0; i-- {
+		if increased {
+			path[i].adjustSorted(key, t.maxSortedLen)
+		} else if ranked[i] {
+			// Another keyword of the subtree may outrank key now.
+			path[i].rebuildSorted(t.maxSortedLen)
+		}
+	}
+}
+
+// Delete removes the keyword str, it returns false if str is not found.
+func (t *TireKWP) Delete(str string) bool {
+	t.begin()
+	defer t.commit()
+	r := t.runes(str)
+	path := t.find(r)
+	if path == nil {
+		return false
+	}
+
+	t.delete(path, r)
+	t.len--
+	return true
+}
+
+// delete removes the key of the last node of path, r is the runes of the key.
+func (t *TireKWP) delete(path []*node, r []rune) {
+	last := len(path) - 1
+	key := path[last].key
+	path[last].key = nil
+	for i := range path {
+		path[i].total -= key.Weight
+		path[i].count--
+	}
+
+	// Remove the nodes which have neither key nor child, except root.
+	for ; last > 0 && path[last].key == nil && path[last].next.len() <= 0; last-- {
+		path[last-1].next.del(r[last-1])
+		t.nNodes--
+	}
+
+	// If only one keyword is left under a node, the node points to it directly, the same as put does,
+	// so the chain of nodes down to the keyword collapses. Root always keeps its children.
+	for i := last; i > 0 && path[i].key == nil && path[i].next.len() == 1; i-- {
+		var r rune
+		var only *node
+		path[i].next.each(func(cr rune, child *node) bool {
+			r, only = cr, child
+			return false
+		})
+		if only.key == nil || only.next.len() > 0 {
+			break
+		}
+		path[i].key = only.key
+		path[i].next.del(r)
+		t.nNodes--
+		last = i
+	}
+
+	// The ancestors which ranked key in their sorted need to pick another keyword from their subtree.
+	// If a node didn't rank key, its ancestors didn't either.
+	for i := last; i >= 0; i-- {
+		if _, ok := path[i].sorted.Get(key); !ok {
+			break
+		}
+		path[i].rebuildSorted(t.maxSortedLen)
+	}
+}
+
+// Rename changes the string of keyword old to new, the weight is kept.
+// It returns false and changes nothing if old is not found, or new is already a keyword other than old.
+func (t *TireKWP) Rename(old, new string) bool {
+	newR := t.runes(new)
+	if len(newR) <= 0 {
+		panic("Can't put an empty string to tireKWP.")
+	}
+
+	t.begin()
+	defer t.commit()
+	oldR := t.runes(old)
+	path := t.find(oldR)
+	if path == nil {
+		return false
+	}
+	if string(newR) != string(oldR) {
+		if n := t.get(t.root, newR); n != nil && n.key != nil && len(n.key.str) == len(newR) {
+			return false
+		}
+	}
+
+	weight := path[len(path)-1].key.Weight
+	t.delete(path, oldR)
+	t.len--
+	t.insert(new, weight)
+	return true
+}
+
+// Trim deletes the lowest-weighted keywords until Len() <= maxKeywords, and returns the count of deleted keywords.
+// If weights are same, the greater string is deleted first, the same as the order of Get.
+// O(NlogN)
+func (t *TireKWP) Trim(maxKeywords int) int {
+	if maxKeywords < 0 {
+		maxKeywords = 0
+	}
+	v := t.view()
+	if v.len <= maxKeywords {
+		return 0
+	}
+
+	keys := v.root.sortedKeywords()
+	removed := 0
+	for _, key := range keys[maxKeywords:] {
+		if t.Delete(key.Str) {
+			removed++
+		}
+	}
+	return removed
+}
+
+// Decay multiplies the weight of each keyword by factor, truncated toward zero, then deletes the keywords
+// whose weight is less than floor, and returns the count of deleted keywords.
+// The trie is rebuilt with new keywords, so the *Keyword got from GetKWs before keep their old weights.
+// O(NlogN)
+func (t *TireKWP) Decay(factor float64, floor int) int {
+	t.begin()
+	defer t.commit()
+	return t.rebuild(func(key *Keyword) *Keyword {
+		weight := int(float64(key.Weight) * factor)
+		if weight < floor {
+			return nil
+		}
+		return &Keyword{Weight: weight, Str: key.Str, str: key.str}
+	})
+}
+
+// Optimize rebuilds the trie from its keywords into the shape which puts them into a new TireKWP, the keywords are kept.
+// It reclaims the memory of the structure left by many Puts and Deletes, such as the hash-maps of children
+// which are promoted but then shrink.
+// O(NlogN)
+func (t *TireKWP) Optimize() {
+	t.begin()
+	defer t.commit()
+	t.rebuild(func(key *Keyword) *Keyword {
+		return key
+	})
+}
+
+// rebuild rebuilds the trie from the keywords mapped by fn, the keyword is dropped if fn returns nil.
+// It returns the count of dropped keywords.
+func (t *TireKWP) rebuild(fn func(key *Keyword) *Keyword) int {
+	keys := t.root.sortedKeywords()
+	t.root, t.len, t.nNodes = newNode(nil), 0, 1
+	removed := 0
+	for _, key := range keys {
+		if key = fn(key); key == nil {
+			removed++
+			continue
+		}
+		t.put(key)
+		t.len++
+	}
+	return removed
+}
+
+// Export returns copies of all keywords, sorted by DESC of weight, then ASC of string.
+// O(NlogN)
+func (t *TireKWP) Export() []Keyword {
+	keys := t.view().root.sortedKeywords()
+	res := make([]Keyword, len(keys))
+	for i := range keys {
+		res[i] = *keys[i]
+	}
+	return res
+}
+
+func (t *TireKWP) Get(str string) []string {
+	keys := t.candidates(str)
+
 	res := *((*[]string)(unsafe.Pointer(&keys)))
 	for i := range keys {
 		res[i] = keys[i].(*Keyword).Str
@@ -171,16 +476,7 @@ func (t *TireKWP) Get(str string) []string {
 }
 
 func (t *TireKWP) GetKWs(str string) []*Keyword {
-	var keys []interface{}
-	if str == "" {
-		keys = t.root.sorted.Keys()
-	} else {
-		r := []rune(str)
-		n := t.get(r)
-		if n != nil {
-			keys = n.sorted.Keys()
-		}
-	}
+	keys := t.candidates(str)
 
 	res := make([]*Keyword, len(keys))
 	for i := range keys {
@@ -189,11 +485,281 @@ func (t *TireKWP) GetKWs(str string) []*Keyword {
 	return res
 }
 
-func (t *TireKWP) get(str []rune) *node {
-	now := t.root
+// Best returns a copy of the top keyword for prefix str, the same as GetKWs(str)[0], or false if none.
+// It doesn't allocate a slice, useful for inline completion.
+func (t *TireKWP) Best(str string) (Keyword, bool) {
+	if t.score != nil {
+		if keys := t.candidates(str); len(keys) > 0 {
+			return *keys[0].(*Keyword), true
+		}
+		return Keyword{}, false
+	}
+
+	n := t.prefix(str)
+	if n == nil || n.sorted.Len() <= 0 {
+		return Keyword{}, false
+	}
+	key, _ := n.sorted.Min()
+	return *key.(*Keyword), true
+}
+
+// GetTopK returns copies of the first k keywords of GetKWs(str), with their weights.
+func (t *TireKWP) GetTopK(str string, k int) []Keyword {
+	if k <= 0 {
+		return nil
+	}
+	keys := t.candidates(str)
+	if k < len(keys) {
+		keys = keys[:k]
+	}
+
+	res := make([]Keyword, len(keys))
+	for i := range keys {
+		res[i] = *keys[i].(*Keyword)
+	}
+	return res
+}
+
+// Highlight is a suggestion with the rune offsets [Start, End) of the part of Keyword.Str matched by the query.
+type Highlight struct {
+	Keyword
+	Start, End int
+}
+
+// GetHighlighted returns copies of the keywords of GetKWs(str), each with the offsets where str matched it.
+// Suggestions are prefix matches, so Start is 0 and End is the rune length of str, capped at the rune length of the keyword
+// in case the folding of NewNormalized or NewWithTransform changes the length.
+func (t *TireKWP) GetHighlighted(str string) []Highlight {
+	keys := t.candidates(str)
+	end := len([]rune(str))
+
+	res := make([]Highlight, len(keys))
+	for i := range keys {
+		kw := keys[i].(*Keyword)
+		res[i] = Highlight{Keyword: *kw, Start: 0, End: end}
+		if n := len([]rune(kw.Str)); n < end {
+			res[i].End = n
+		}
+	}
+	return res
+}
+
+// MaxWildcards is the maximum count of '?' in the pattern of GetWildcard, each '?' may multiply the nodes to visit.
+const MaxWildcards = 4
+
+// GetWildcard returns copies of the top maxSortedLen keywords starting with pattern, where '?' matches any single rune,
+// sorted by DESC of weight, then ASC of string. For example, "g?lang" finds "golang".
+// It returns nil if pattern has more than MaxWildcards '?'. The folding of NewNormalized or NewWithTransform
+// is applied to pattern, '?' must be kept by it.
+// The walk branches to every child at each '?', so it visits up to the product of the fan-outs at the '?',
+// and sorts the top maxSortedLen keywords of each matching node. The visits are not capped otherwise.
+// O(V + MlogM), V is the count of visited nodes, M is maxSortedLen times the count of matching nodes
+func (t *TireKWP) GetWildcard(pattern string) []Keyword {
+	r := t.runes(pattern)
+	wildcards := 0
+	for i := range r {
+		if r[i] == '?' {
+			wildcards++
+		}
+	}
+	if wildcards > MaxWildcards {
+		return nil
+	}
+
+	var keys []*Keyword
+	t.wildcard(t.view().root, r, 0, func(n *node) {
+		for _, key := range n.sorted.Keys() {
+			keys = append(keys, key.(*Keyword))
+		}
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return cmp(keys[i], keys[j]) < 0
+	})
+	if len(keys) > t.maxSortedLen {
+		keys = keys[:t.maxSortedLen]
+	}
+
+	res := make([]Keyword, len(keys))
+	for i := range keys {
+		res[i] = *keys[i]
+	}
+	return res
+}
+
+// GetAll returns copies of all keywords starting with str, sorted by DESC of weight, then ASC of string.
+// Unlike Get, it is not limited by maxSortedLen, but it traversals the whole subtree and sorts it,
+// O(MlogM), M is the count of matches, while Get only reads the precomputed candidates.
+func (t *TireKWP) GetAll(str string) []Keyword {
+	n := t.prefix(str)
+	if n == nil {
+		return nil
+	}
+
+	keys := n.sortedKeywords()
+	res := make([]Keyword, len(keys))
+	for i := range keys {
+		res[i] = *keys[i]
+	}
+	return res
+}
+
+// GetMaxLen returns the keywords of Get(str) whose rune length is no more than maxRunes, in the same order.
+// The filter applies to the precomputed candidates, so fewer than maxSortedLen keywords may be returned
+// even if more short keywords start with str.
+func (t *TireKWP) GetMaxLen(str string, maxRunes int) []string {
+	var res []string
+	for _, key := range t.candidates(str) {
+		if kw := key.(*Keyword); len([]rune(kw.Str)) <= maxRunes {
+			res = append(res, kw.Str)
+		}
+	}
+	return res
+}
+
+// GetFiltered returns copies of the keywords of GetKWs(str) for which allow returns true, in the same order.
+// Like GetMaxLen, it filters the top maxSortedLen candidates, not all keywords starting with str.
+func (t *TireKWP) GetFiltered(str string, allow func(*Keyword) bool) []Keyword {
+	var res []Keyword
+	for _, key := range t.candidates(str) {
+		if kw := key.(*Keyword); allow(kw) {
+			res = append(res, *kw)
+		}
+	}
+	return res
+}
+
+// GetAlpha returns the same keywords as Get but in ASC of string.
+// They are the top maxSortedLen keywords by weight reordered alphabetically, not the alphabetical top of all matches.
+func (t *TireKWP) GetAlpha(str string) []string {
+	var res []string
+	if n := t.prefix(str); n != nil {
+		keys := n.sorted.Keys()
+		res = make([]string, len(keys))
+		for i := range keys {
+			res[i] = keys[i].(*Keyword).Str
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// GetWeighted returns the same keywords as Get with their weights.
+// The order is lost in a map, it's for the callers doing their own ranking.
+func (t *TireKWP) GetWeighted(str string) map[string]int {
+	res := make(map[string]int)
+	if n := t.prefix(str); n != nil {
+		for _, key := range n.sorted.Keys() {
+			kw := key.(*Keyword)
+			res[kw.Str] = kw.Weight
+		}
+	}
+	return res
+}
+
+// Precompute returns copies of the keywords of GetKWs for each prefix, keyed by the prefix.
+// It's for warming a cache, the prefixes are walked in ASC, so the nodes shared by neighbouring prefixes are walked once.
+func (t *TireKWP) Precompute(prefixes []string) map[string][]Keyword {
+	root := t.view().root
+	qs := make([]string, len(prefixes)) // the converted runes of prefixes, as strings to be sorted
+	order := make([]int, len(prefixes))
+	for i := range prefixes {
+		qs[i] = string(t.runes(prefixes[i]))
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return qs[order[i]] < qs[order[j]] // the order of UTF-8 strings is the order of runes
+	})
+
+	res := make(map[string][]Keyword, len(prefixes))
+	path := []*node{root} // path[i] is the node to the first i runes of prev
+	var prev []rune
+	for _, i := range order {
+		q := []rune(qs[i])
+		d := 0
+		for d < len(q) && d < len(path)-1 && q[d] == prev[d] {
+			d++
+		}
+		path = path[:d+1]
+
+		n := path[d]
+		for ; d < len(q) && n.next.len() > 0; d++ {
+			next, ok := n.next.get(q[d])
+			if !ok {
+				n = nil
+				break
+			}
+			n = next
+			path = append(path, n)
+		}
+		if n != nil && d < len(q) && n.key == nil {
+			n = nil // only the root of an empty trie is a leaf without key
+		}
+		if n != nil && n.key != nil {
+			// A leaf may hold a keyword longer than its depth, which must start with q.
+			if len(n.key.str) < len(q) || string(n.key.str[:len(q)]) != qs[i] {
+				n = nil
+			}
+		}
+		prev = q
+
+		keys := t.ranked(n, prefixes[i])
+		kws := make([]Keyword, len(keys))
+		for j := range keys {
+			kws[j] = *keys[j].(*Keyword)
+		}
+		res[prefixes[i]] = kws
+	}
+	return res
+}
+
+// prefix returns the node to prefix str, or nil if no keyword starts with str.
+func (t *TireKWP) prefix(str string) *node {
+	root := t.view().root
+	if str == "" {
+		return root
+	}
+	return t.get(root, t.runes(str))
+}
+
+// candidates returns the sorted keywords of the node to prefix str, they are re-ranked if t.score is set.
+func (t *TireKWP) candidates(str string) []interface{} {
+	return t.ranked(t.prefix(str), str)
+}
+
+// ranked returns the sorted keywords of n, which is the node to prefix str or nil,
+// they are re-ranked if t.score is set.
+func (t *TireKWP) ranked(n *node, str string) []interface{} {
+	var keys []interface{}
+	if n != nil {
+		keys = n.sorted.Keys()
+	}
+
+	if t.score != nil && len(keys) > 1 {
+		type scored struct {
+			key   interface{}
+			score float64
+		}
+		ranked := make([]scored, len(keys))
+		for i := range keys {
+			ranked[i] = scored{key: keys[i], score: t.score(keys[i].(*Keyword), str)}
+		}
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return ranked[i].score > ranked[j].score // DESC of score, same scores keep the order of weight
+		})
+		for i := range ranked {
+			keys[i] = ranked[i].key
+		}
+	}
+	return keys
+}
+
+// get returns the node to prefix str in the trie of root, or nil if no keyword starts with str.
+// Readers pass the root of view, writers pass t.root which is being modified.
+func (t *TireKWP) get(root *node, str []rune) *node {
+	now := root
 	ok := false
 	for pos := 0; pos < len(str); pos++ {
-		if len(now.next) <= 0 {
+		if now.next.len() <= 0 {
 			if now.key != nil && len(now.key.str) >= len(str) {
 				for i := pos; i < len(str); i++ {
 					if str[i] != now.key.str[i] {
@@ -205,7 +771,7 @@ func (t *TireKWP) get(str []rune) *node {
 				return nil
 			}
 		}
-		now, ok = now.next[str[pos]]
+		now, ok = now.next.get(str[pos])
 		if !ok {
 			return nil
 		}
@@ -223,12 +789,273 @@ func (t *TireKWP) get(str []rune) *node {
 	}
 }
 
+// wildcard calls fn for each node whose subtree has the keywords matching pattern, pos runes of pattern are matched by n.
+// The subtrees of the nodes are disjoint.
+func (t *TireKWP) wildcard(n *node, pattern []rune, pos int, fn func(n *node)) {
+	if pos == len(pattern) {
+		fn(n)
+		return
+	}
+
+	if n.next.len() <= 0 {
+		// A leaf node may store a longer keyword, the rest of pattern is matched by it.
+		if n.key == nil || len(n.key.str) < len(pattern) {
+			return
+		}
+		for i := pos; i < len(pattern); i++ {
+			if pattern[i] != '?' && pattern[i] != n.key.str[i] {
+				return
+			}
+		}
+		fn(n)
+		return
+	}
+
+	if pattern[pos] == '?' {
+		n.next.each(func(_ rune, child *node) bool {
+			t.wildcard(child, pattern, pos+1, fn)
+			return true
+		})
+	} else if child, ok := n.next.get(pattern[pos]); ok {
+		t.wildcard(child, pattern, pos+1, fn)
+	}
+}
+
+// find returns the nodes from root to the node storing the keyword whose runes are str, or nil if not found.
+// In copy-on-write mode the nodes on the path are cloned for the current write.
+func (t *TireKWP) find(str []rune) []*node {
+	now := t.root
+	path := []*node{now}
+	for pos := 0; pos < len(str) && now.next.len() > 0; pos++ {
+		next, ok := t.next(now, str[pos])
+		if !ok {
+			return nil
+		}
+		now = next
+		path = append(path, now)
+	}
+
+	if now.key == nil || len(now.key.str) != len(str) {
+		return nil
+	}
+	for i := range str {
+		if str[i] != now.key.str[i] {
+			return nil
+		}
+	}
+	return path
+}
+
+// runes converts str to the runes used as the trie path.
+func (t *TireKWP) runes(str string) []rune {
+	if t.fold != nil {
+		return t.fold(str)
+	}
+	return []rune(str)
+}
+
+// MatchPrefix returns a copy of the longest keyword which query starts with, or false if none.
+// It's the reverse of Get, for example, with "a", "ab" and "abcd", MatchPrefix("abc") returns "ab".
+// O(len(query))
+func (t *TireKWP) MatchPrefix(query string) (Keyword, bool) {
+	q := t.runes(query)
+	var best *Keyword
+	now := t.view().root
+	for pos := 0; ; pos++ {
+		// The keyword of a node matches the path to it, a leaf node may store a longer keyword to check.
+		if key := now.key; key != nil && len(key.str) <= len(q) {
+			i := pos
+			for i < len(key.str) && key.str[i] == q[i] {
+				i++
+			}
+			if i == len(key.str) {
+				best = key
+			}
+		}
+		if pos >= len(q) {
+			break
+		}
+		next, ok := now.next.get(q[pos])
+		if !ok {
+			break
+		}
+		now = next
+	}
+
+	if best == nil {
+		return Keyword{}, false
+	}
+	return *best, true
+}
+
+// SuggestionCount returns the count of keywords Get(str) returns, it is no more than maxSortedLen.
+// O(len(str))
+func (t *TireKWP) SuggestionCount(str string) int {
+	if n := t.prefix(str); n != nil {
+		return n.sorted.Len()
+	}
+	return 0
+}
+
+// CountPrefix returns the count of all keywords starting with str, so that with SuggestionCount
+// the caller can show "10 of 342".
+// O(len(str))
+func (t *TireKWP) CountPrefix(str string) int {
+	if n := t.prefix(str); n != nil {
+		return n.count
+	}
+	return 0
+}
+
+// Crumb is a level of Breadcrumb, Count is the count of keywords starting with Prefix.
+type Crumb struct {
+	Prefix string
+	Count  int
+}
+
+// Breadcrumb returns a Crumb for each prefix of str, from the first rune to the whole str, such as
+// g (3000) -> go (420) -> gol (12). It stops before the first prefix no keyword starts with.
+// Prefix is converted from str like the keywords, Count is CountPrefix(Prefix).
+// O(len(str))
+func (t *TireKWP) Breadcrumb(str string) []Crumb {
+	q := t.runes(str)
+	var res []Crumb
+	now := t.view().root
+	for pos := 0; pos < len(q); pos++ {
+		if now.next.len() <= 0 {
+			// now is a leaf, its keyword may be longer than its depth, the deeper prefixes must match it.
+			if now.key == nil || len(now.key.str) <= pos || now.key.str[pos] != q[pos] {
+				break
+			}
+		} else if next, ok := now.next.get(q[pos]); ok {
+			now = next
+		} else {
+			break
+		}
+		res = append(res, Crumb{Prefix: string(q[:pos+1]), Count: now.count})
+	}
+	return res
+}
+
+// PrefixWeight returns the sum of the weights of all keywords starting with str, not limited by maxSortedLen.
+// O(len(str))
+func (t *TireKWP) PrefixWeight(str string) int {
+	if n := t.prefix(str); n != nil {
+		return n.total
+	}
+	return 0
+}
+
+// PrefixStats returns the structure of the subtree under prefix str: the count of keywords starting with str,
+// the count of nodes and the count of levels, the node of str itself is the first level.
+// ok is false if no keyword starts with str.
+// O(subtree)
+func (t *TireKWP) PrefixStats(str string) (matchingKeywords, subtreeNodes, maxDepth int, ok bool) {
+	n := t.prefix(str)
+	if n == nil {
+		return 0, 0, 0, false
+	}
+	matchingKeywords, subtreeNodes, maxDepth = n.stats()
+	return matchingKeywords, subtreeNodes, maxDepth, true
+}
+
+// Validate checks the internal invariants, and returns an error describing the first inconsistency:
+// 1. the sorted of each node is exactly the top maxSortedLen keywords of its subtree.
+// 2. Len() and Count() match the keywords and nodes found by a traversal.
+// 3. the runes of each keyword match the path to it.
+// 4. the total weight and the keyword count of each node match its subtree.
+// O(N)
+func (t *TireKWP) Validate() error {
+	v := t.view()
+	if v.root.key != nil {
+		return fmt.Errorf("tirekwp: root stores keyword %q", v.root.key.Str)
+	}
+
+	keys, nodes := 0, 0
+	if _, _, _, err := t.validate(v.root, nil, &keys, &nodes); err != nil {
+		return err
+	}
+	if keys != v.len {
+		return fmt.Errorf("tirekwp: Len() is %d, but %d keywords are found", v.len, keys)
+	}
+	if nodes != v.nNodes {
+		return fmt.Errorf("tirekwp: Count() is %d, but %d nodes are found", v.nNodes, nodes)
+	}
+	return nil
+}
+
+// validate checks the subtree n whose path from root is path,
+// and returns the top keywords, the sum of the weights and the count of keywords of the subtree.
+func (t *TireKWP) validate(n *node, path []rune, keys, nodes *int) ([]*Keyword, int, int, error) {
+	*nodes++
+	var top []*Keyword
+	total, count := 0, 0
+	if n.key != nil {
+		*keys++
+		key := n.key
+		total = key.Weight
+		count = 1
+		if len(key.str) < len(path) || string(key.str[:len(path)]) != string(path) {
+			return nil, 0, 0, fmt.Errorf("tirekwp: keyword %q is stored under prefix %q", key.Str, string(path))
+		}
+		if n.next.len() > 0 && len(key.str) != len(path) {
+			return nil, 0, 0, fmt.Errorf("tirekwp: keyword %q is stored in a non-leaf node of prefix %q", key.Str, string(path))
+		}
+		if string(key.str) != string(t.runes(key.Str)) {
+			return nil, 0, 0, fmt.Errorf("tirekwp: runes of keyword %q don't match its string", key.Str)
+		}
+		top = append(top, key)
+	} else if len(path) > 0 && n.next.len() <= 0 {
+		return nil, 0, 0, fmt.Errorf("tirekwp: leaf node of prefix %q has no keyword", string(path))
+	}
+
+	var err error
+	n.next.each(func(r rune, child *node) bool {
+		var sub []*Keyword
+		var subTotal, subCount int
+		if sub, subTotal, subCount, err = t.validate(child, append(path, r), keys, nodes); err != nil {
+			return false
+		}
+		top = append(top, sub...)
+		total += subTotal
+		count += subCount
+		return true
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		return cmp(top[i], top[j]) < 0
+	})
+	if len(top) > t.maxSortedLen {
+		top = top[:t.maxSortedLen]
+	}
+	sorted := n.sorted.Keys()
+	if len(sorted) != len(top) {
+		return nil, 0, 0, fmt.Errorf("tirekwp: sorted of prefix %q has %d keywords, expected %d", string(path), len(sorted), len(top))
+	}
+	for i := range top {
+		if sorted[i].(*Keyword) != top[i] {
+			return nil, 0, 0, fmt.Errorf("tirekwp: sorted of prefix %q has %q at %d, expected %q",
+				string(path), sorted[i].(*Keyword).Str, i, top[i].Str)
+		}
+	}
+	if n.total != total {
+		return nil, 0, 0, fmt.Errorf("tirekwp: total weight of prefix %q is %d, expected %d", string(path), n.total, total)
+	}
+	if n.count != count {
+		return nil, 0, 0, fmt.Errorf("tirekwp: keyword count of prefix %q is %d, expected %d", string(path), n.count, count)
+	}
+	return top, total, count, nil
+}
+
 func (t *TireKWP) Len() int {
-	return t.len
+	return t.view().len
 }
 
 func (t *TireKWP) Count() int {
-	return t.nNodes
+	return t.view().nNodes
 }
 
 type node struct {
@@ -238,28 +1065,178 @@ type node struct {
 		3. Other times, key == nil
 	*/
 	key    *Keyword
-	next   map[rune]*node // For now, hash-map is fastest for search.
+	total  int            // Sum of the weights of all keywords in the subtree.
+	count  int            // Count of keywords in the subtree.
+	next   children       // Small sorted slice for low fan-out, hash-map after promotion.
 	sorted orderedmap.Any // The ordered keywords of each node are maintained during put() and delete(), so that get() can get quick response.
 }
 
 func (n *node) adjustSorted(key *Keyword, maxLen int) {
+	if n.sorted.Len() >= maxLen {
+		if least, _ := n.sorted.Max(); least != nil && cmp(key, least) > 0 {
+			return // key is not ranked
+		}
+	}
 	n.sorted.Put(key, nil)
 	if n.sorted.Len() > maxLen {
 		_, _ = n.sorted.PopMax() // max is the least weight
 	}
 }
 
+// adjust adjusts n.sorted for key, or marks n.sorted to rebuild by rebuildDeferred if t.deferSorted.
+func (t *TireKWP) adjust(n *node, key *Keyword) {
+	if t.deferSorted {
+		n.sorted = nil
+		return
+	}
+	n.adjustSorted(key, t.maxSortedLen)
+}
+
+// rebuildDeferred rebuilds the sorted marked by adjust in the subtree n, the children before their parent.
+// The ancestors of a marked node are marked, so the subtrees of the unmarked children are skipped.
+func (n *node) rebuildDeferred(m *merger, maxLen int) {
+	if n.sorted != nil {
+		return
+	}
+	n.next.each(func(_ rune, child *node) bool {
+		child.rebuildDeferred(m, maxLen)
+		return true
+	})
+	m.rebuild(n, maxLen)
+}
+
+// rebuildSorted recomputes n.sorted from n.key and the sorted of n's children.
+func (n *node) rebuildSorted(maxLen int) {
+	var m merger
+	m.rebuild(n, maxLen)
+}
+
+// merger merges the sorted of the children of a node, it keeps its buffers for the next node.
+type merger struct {
+	keys  []interface{} // the candidates, they are runs in the order of cmp
+	heads []int         // heads[i] is the index in keys of the next candidate of run i
+	ends  []int         // ends[i] is the end of run i in keys
+}
+
+// rebuild recomputes n.sorted from n.key and the sorted of n's children.
+// The sorted of the children are in the order of cmp, so the top keywords are merged from their heads,
+// each of them is put once and none is popped.
+func (m *merger) rebuild(n *node, maxLen int) {
+	m.keys, m.heads, m.ends = m.keys[:0], m.heads[:0], m.ends[:0]
+	add := func(keys ...interface{}) {
+		m.heads = append(m.heads, len(m.keys))
+		m.keys = append(m.keys, keys...)
+		m.ends = append(m.ends, len(m.keys))
+	}
+	if n.key != nil {
+		add(n.key)
+	}
+	n.next.each(func(_ rune, child *node) bool {
+		if child.next.len() <= 0 {
+			add(child.key) // the sorted of a leaf is its key, which saves a copy by Keys
+		} else {
+			add(child.sorted.Keys()...)
+		}
+		return true
+	})
+
+	n.sorted = orderedmap.NewAny(cmp)
+	for n.sorted.Len() < maxLen {
+		best := -1
+		for i := range m.heads {
+			if m.heads[i] < m.ends[i] && (best < 0 || ranks(m.keys[m.heads[i]], m.keys[m.heads[best]])) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		n.sorted.Put(m.keys[m.heads[best]], nil)
+		m.heads[best]++
+	}
+}
+
+// stats returns the count of keywords, the count of nodes and the count of levels of the subtree n.
+func (n *node) stats() (keys, nodes, depth int) {
+	if n.key != nil {
+		keys = 1
+	}
+	nodes = 1
+	n.next.each(func(_ rune, child *node) bool {
+		k, c, d := child.stats()
+		keys += k
+		nodes += c
+		if d > depth {
+			depth = d
+		}
+		return true
+	})
+	return keys, nodes, depth + 1
+}
+
+// sortedKeywords returns all keywords of the subtree n sorted by cmp, each keyword is stored in exactly one node.
+func (n *node) sortedKeywords() []*Keyword {
+	var keys []*Keyword
+	n.walk(func(n *node) bool {
+		if n.key != nil {
+			keys = append(keys, n.key)
+		}
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return cmp(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+// walk calls fn for each node of the subtree n in pre-order until fn returns false.
+// It returns false if the walk was stopped by fn.
+func (n *node) walk(fn func(n *node) bool) bool {
+	if !fn(n) {
+		return false
+	}
+	return n.next.each(func(_ rune, child *node) bool {
+		return child.walk(fn)
+	})
+}
+
 // cmp compare key1 and key2 for orderedmap
 // Level 1, DESC of weight.
 // Level 2, if weights are same, ASC of string
+// It is a total order, so that the overflow of sorted always pops the same keyword, whatever the order of Puts is.
+// Weights are compared instead of subtracted, which may overflow for extreme weights and break the order.
 func cmp(key1, key2 interface{}) int {
 	k1, k2 := key1.(*Keyword), key2.(*Keyword)
 	c := strings.Compare(k1.Str, k2.Str)
 	if c == 0 {
 		return 0
-	} else if k1.Weight != k2.Weight {
-		return k2.Weight - k1.Weight // DESC of weight
+	} else if k1.Weight > k2.Weight {
+		return -1 // DESC of weight
+	} else if k1.Weight < k2.Weight {
+		return 1
 	} else {
 		return c // if weights are same, ASC of string
 	}
 }
+
+// ranks returns whether key1 is before key2 in the order of cmp, the keywords must be different.
+// It saves the string comparison of cmp when the weights are different.
+func ranks(key1, key2 interface{}) bool {
+	k1, k2 := key1.(*Keyword), key2.(*Keyword)
+	if k1.Weight != k2.Weight {
+		return k1.Weight > k2.Weight
+	}
+	return k1.Str < k2.Str
+}
+
+// normalize lowercases str, decomposes it by NFKD and strips the combining marks, "Café" -> "cafe".
+func normalize(str string) []rune {
+	rs := []rune(norm.NFKD.String(strings.ToLower(str)))
+	res := rs[:0]
+	for _, r := range rs {
+		if !unicode.Is(unicode.Mn, r) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
@@ -0,0 +1,721 @@
+package tirekwp
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/shengmingzhu/orderedmap"
+)
+
+// genKeywords returns n random keywords of 1 to maxLen runes from alphabet, with weights in [0, 100).
+// A small alphabet gives deep shared prefixes and repeated keywords.
+func genKeywords(r *rand.Rand, n, maxLen int, alphabet string) []Keyword {
+	rs := []rune(alphabet)
+	res := make([]Keyword, n)
+	for i := range res {
+		str := make([]rune, 1+r.Intn(maxLen))
+		for j := range str {
+			str[j] = rs[r.Intn(len(rs))]
+		}
+		res[i] = Keyword{Str: string(str), Weight: r.Intn(100)}
+	}
+	return res
+}
+
+// mustSame fails tb if a and b don't give the same suggestions for each prefix of the keywords.
+func mustSame(tb testing.TB, a, b *TireKWP, kws []Keyword) {
+	tb.Helper()
+	if err := b.Validate(); err != nil {
+		tb.Fatal(err)
+	}
+	if a.Len() != b.Len() || a.Count() != b.Count() {
+		tb.Fatalf("Len %d vs %d, Count %d vs %d", a.Len(), b.Len(), a.Count(), b.Count())
+	}
+	for _, kw := range kws {
+		rs := []rune(kw.Str)
+		for i := 0; i <= len(rs); i++ {
+			p := string(rs[:i])
+			if got, want := b.Get(p), a.Get(p); !reflect.DeepEqual(got, want) {
+				tb.Fatalf("Get(%q) got %v, want %v", p, got, want)
+			}
+		}
+	}
+}
+
+func TestPutAll(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for name, mk := range map[string]func(int) *TireKWP{"New": New, "NewCOW": NewCOW, "NewNormalized": NewNormalized} {
+		for _, maxSortedLen := range []int{1, 3, 10} {
+			a, b := mk(maxSortedLen), mk(maxSortedLen)
+			// PutAll must also be right on a trie which has keywords, and with repeated keywords in the batch.
+			for _, batch := range [][]Keyword{genKeywords(r, 300, 6, "abcAB"), genKeywords(r, 1000, 8, "abcdAB")} {
+				for _, kw := range batch {
+					a.Put(kw.Str, kw.Weight)
+				}
+				b.PutAll(batch)
+				mustSame(t, a, b, batch)
+			}
+			if t.Failed() {
+				t.Fatalf("%s(%d)", name, maxSortedLen)
+			}
+
+			// The trie stays usable after PutAll.
+			a.Put("abcabc", 1000)
+			b.Put("abcabc", 1000)
+			a.Delete("ab")
+			b.Delete("ab")
+			mustSame(t, a, b, []Keyword{{Str: "abcabc"}, {Str: "ab"}})
+		}
+	}
+}
+
+func BenchmarkPutAll(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	kws := make([]Keyword, 50000)
+	for i := range kws {
+		kws[i] = Keyword{Str: fmt.Sprintf("%x", r.Int63()), Weight: r.Intn(1000)}
+	}
+
+	b.Run("Put", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			t := New(10)
+			for _, kw := range kws {
+				t.Put(kw.Str, kw.Weight)
+			}
+		}
+	})
+	b.Run("PutAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			New(10).PutAll(kws)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	build := func() *TireKWP {
+		tr := New(2)
+		for _, kw := range []Keyword{{Str: "go", Weight: 5}, {Str: "golf", Weight: 3}, {Str: "gopher", Weight: 8}, {Str: "game", Weight: 1}, {Str: "java", Weight: 2}} {
+			tr.Put(kw.Str, kw.Weight)
+		}
+		if err := tr.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		return tr
+	}
+	child := func(n *node, r rune) *node {
+		c, _ := n.next.get(r)
+		return c
+	}
+	for name, corrupt := range map[string]func(tr *TireKWP){
+		"root total":  func(tr *TireKWP) { tr.root.total++ },
+		"child count": func(tr *TireKWP) { child(tr.root, 'g').count-- },
+		"len":         func(tr *TireKWP) { tr.len++ },
+		"node count":  func(tr *TireKWP) { tr.nNodes-- },
+		"sorted order": func(tr *TireKWP) {
+			reversed := orderedmap.NewAny(func(a, b interface{}) int { return cmp(b, a) })
+			for _, k := range tr.root.sorted.Keys() {
+				reversed.Put(k, nil)
+			}
+			tr.root.sorted = reversed
+		},
+		"sorted missing keyword": func(tr *TireKWP) {
+			g := child(tr.root, 'g')
+			top, _ := g.sorted.Min()
+			g.sorted.Delete(top)
+		},
+		"sorted extra keyword": func(tr *TireKWP) {
+			g := child(tr.root, 'g')
+			g.sorted.Put(&Keyword{Weight: 100, Str: "gx", str: []rune("gx")}, nil)
+		},
+	} {
+		tr := build()
+		corrupt(tr)
+		if err := tr.Validate(); err == nil {
+			t.Fatalf("Validate of a trie with a wrong %s got nil", name)
+		}
+	}
+}
+
+// build returns a trie of maxSortedLen with kws put in order.
+func build(maxSortedLen int, kws []Keyword) *TireKWP {
+	t := New(maxSortedLen)
+	for _, kw := range kws {
+		t.Put(kw.Str, kw.Weight)
+	}
+	return t
+}
+
+func TestTrim(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	kws := genKeywords(r, 500, 5, "abc")
+	tr := build(3, kws)
+	all := tr.Export()
+	const max = 40
+	if n := tr.Trim(max); n != len(all)-max {
+		t.Fatalf("Trim got %d, want %d", n, len(all)-max)
+	}
+	// The survivors are the top keywords in the order of Get, and the suggestions are the same as a trie of them.
+	if got := tr.Export(); !reflect.DeepEqual(got, all[:max]) {
+		t.Fatalf("Export after Trim got %v, want %v", got, all[:max])
+	}
+	mustSame(t, build(3, all[:max]), tr, kws)
+	if n := tr.Trim(max); n != 0 {
+		t.Fatalf("Trim of a trimmed trie got %d, want 0", n)
+	}
+}
+
+func TestDeleteCompacts(t *testing.T) {
+	tr := New(5)
+	tr.Put("golf", 1)
+	before := tr.Count()
+	tr.Put("gopher", 2) // forks the leaf of "golf" at "go"
+	if tr.Count() <= before {
+		t.Fatalf("Count after the fork got %d, want > %d", tr.Count(), before)
+	}
+	if !tr.Delete("gopher") {
+		t.Fatal("Delete(gopher) got false")
+	}
+	if tr.Count() != before {
+		t.Fatalf("Count after deleting the fork got %d, want %d", tr.Count(), before)
+	}
+	mustSame(t, build(5, []Keyword{{Str: "golf", Weight: 1}}), tr, []Keyword{{Str: "golf"}, {Str: "gopher"}})
+
+	// With "go" stored at the fork, deleting "golf" compacts "gopher" back to one leaf under "go".
+	tr.Put("go", 3)
+	tr.Put("gopher", 2)
+	tr.Delete("golf")
+	mustSame(t, build(5, []Keyword{{Str: "go", Weight: 3}, {Str: "gopher", Weight: 2}}), tr, []Keyword{{Str: "golf"}, {Str: "gopher"}})
+	if tr.Delete("golf") {
+		t.Fatal("Delete of an absent keyword got true")
+	}
+}
+
+func TestNewNormalized(t *testing.T) {
+	tr := NewNormalized(5)
+	tr.Put("café", 2)
+	tr.Put("Cafeteria", 1)
+	for _, query := range []string{"cafe", "CAFÉ", "café", "Caf"} {
+		if got := tr.Get(query); !reflect.DeepEqual(got, []string{"café", "Cafeteria"}) {
+			t.Fatalf("Get(%q) got %q, want the original strings", query, got)
+		}
+	}
+	// The first put wins among the keywords which are the same after normalization.
+	tr.Put("CAFE", 5)
+	if got := tr.Get("cafe"); len(got) != 2 || got[0] != "café" || tr.Len() != 2 {
+		t.Fatalf("Get(cafe) got %q with Len %d", got, tr.Len())
+	}
+	if err := tr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// strs returns the strings of kws, or nil if kws is nil.
+func strs(kws []Keyword) []string {
+	if kws == nil {
+		return nil
+	}
+	res := make([]string, len(kws))
+	for i := range kws {
+		res[i] = kws[i].Str
+	}
+	return res
+}
+
+func TestGetWildcard(t *testing.T) {
+	tr := build(10, []Keyword{{Str: "g", Weight: 1}, {Str: "go", Weight: 5}, {Str: "golang", Weight: 9}, {Str: "gin", Weight: 3},
+		{Str: "gym", Weight: 2}, {Str: "java", Weight: 7}, {Str: "ago", Weight: 4}})
+	for pattern, want := range map[string][]string{
+		"g?":      {"golang", "go", "gin", "gym"}, // all keywords of 2 runes or more starting with g
+		"g?n":     {"gin"},                        // the literal n constrains the walk after '?'
+		"?o":      {"golang", "go"},
+		"g?lang":  {"golang"},
+		"j?v?":    {"java"},
+		"?":       {"golang", "java", "go", "ago", "gin", "gym", "g"},
+		"g?x":     {},
+		"????":    {"golang", "java"},
+		"?????":   nil, // more than MaxWildcards
+		"golang?": {},
+	} {
+		if got := strs(tr.GetWildcard(pattern)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("GetWildcard(%q) got %q, want %q", pattern, got, want)
+		}
+	}
+	if got := strs(build(2, tr.Export()).GetWildcard("g?")); !reflect.DeepEqual(got, []string{"golang", "go"}) {
+		t.Fatalf("GetWildcard(g?) got %q, want the top maxSortedLen", got)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	kws := genKeywords(r, 300, 5, "abc")
+	tr := build(3, kws)
+	all := tr.Export()
+	for _, prefix := range []string{"", "a", "ab", "cab", "abcab", "x"} {
+		want := []string{}
+		for _, kw := range all {
+			if strings.HasPrefix(kw.Str, prefix) {
+				want = append(want, kw.Str)
+			}
+		}
+		got := strs(tr.GetAll(prefix))
+		if len(got) != len(want) || (len(got) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Fatalf("GetAll(%q) got %q, want %q", prefix, got, want)
+		}
+		if get := tr.Get(prefix); len(want) > 3 && (len(got) <= len(get) || !reflect.DeepEqual(got[:len(get)], get)) {
+			t.Fatalf("GetAll(%q) got %d keywords, Get %q, want more starting with the same", prefix, len(got), get)
+		}
+	}
+}
+
+func TestPrefixStats(t *testing.T) {
+	// The trie is root -> g -> {a: "game", o: "go" -> {l: "golf", n: "gone", p: "gopher"}},
+	// a keyword with no other keyword sharing its next rune is stored in one leaf.
+	tr := build(2, []Keyword{{Str: "go", Weight: 1}, {Str: "golf", Weight: 2}, {Str: "gopher", Weight: 3},
+		{Str: "gone", Weight: 4}, {Str: "game", Weight: 5}})
+	if tr.Count() != 7 {
+		t.Fatalf("Count got %d, want 7", tr.Count())
+	}
+	for prefix, want := range map[string][3]int{
+		"go":  {4, 4, 2},
+		"g":   {5, 6, 3},
+		"gop": {1, 1, 1},
+		"":    {5, 7, 4},
+	} {
+		keywords, nodes, depth, ok := tr.PrefixStats(prefix)
+		if !ok || [3]int{keywords, nodes, depth} != want {
+			t.Fatalf("PrefixStats(%q) got %d keywords, %d nodes, %d levels, want %v", prefix, keywords, nodes, depth, want)
+		}
+		if keywords != tr.CountPrefix(prefix) {
+			t.Fatalf("PrefixStats(%q) got %d keywords, CountPrefix %d", prefix, keywords, tr.CountPrefix(prefix))
+		}
+	}
+	if _, _, _, ok := tr.PrefixStats("gx"); ok {
+		t.Fatal("PrefixStats of a prefix without keywords got ok")
+	}
+}
+
+func TestPrefixWeight(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tr := New(2)
+	sums := make(map[string]int)
+	for _, kw := range genKeywords(r, 400, 5, "gol") {
+		if r.Intn(4) == 0 {
+			tr.Delete(kw.Str)
+		} else if r.Intn(2) == 0 {
+			tr.Put(kw.Str, kw.Weight)
+		} else {
+			tr.Add(kw.Str, kw.Weight)
+		}
+	}
+	for _, kw := range tr.Export() {
+		rs := []rune(kw.Str)
+		for i := 0; i <= len(rs); i++ {
+			sums[string(rs[:i])] += kw.Weight
+		}
+	}
+	if tr.CountPrefix("go") <= 2 {
+		t.Fatalf("only %d keywords start with go", tr.CountPrefix("go"))
+	}
+	for prefix, want := range sums {
+		if got := tr.PrefixWeight(prefix); got != want {
+			t.Fatalf("PrefixWeight(%q) got %d, want %d", prefix, got, want)
+		}
+	}
+	if got := tr.PrefixWeight("x"); got != 0 {
+		t.Fatalf("PrefixWeight of a prefix without keywords got %d", got)
+	}
+}
+
+func TestDecay(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	kws := genKeywords(r, 300, 5, "abc")
+	tr := build(3, kws)
+	var want []Keyword
+	dropped := 0
+	for _, kw := range tr.Export() {
+		if w := int(float64(kw.Weight) * 0.5); w >= 20 {
+			want = append(want, Keyword{Str: kw.Str, Weight: w})
+		} else {
+			dropped++
+		}
+	}
+
+	// Truncation may make weights the same, then the keywords are in ASC of string.
+	sort.Slice(want, func(i, j int) bool {
+		return want[i].Weight > want[j].Weight || (want[i].Weight == want[j].Weight && want[i].Str < want[j].Str)
+	})
+
+	if n := tr.Decay(0.5, 20); n != dropped {
+		t.Fatalf("Decay got %d dropped, want %d", n, dropped)
+	}
+	got := tr.Export()
+	if len(got) != len(want) {
+		t.Fatalf("Export after Decay got %d keywords, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].Str != want[i].Str || got[i].Weight != want[i].Weight {
+			t.Fatalf("Export after Decay got %v at %d, want %v", got[i], i, want[i])
+		}
+	}
+	// The suggestions are the same as a trie of the decayed keywords.
+	mustSame(t, build(3, want), tr, kws)
+}
+
+func TestRename(t *testing.T) {
+	tr := build(3, []Keyword{{Str: "colour", Weight: 7}, {Str: "color", Weight: 2}, {Str: "cold", Weight: 5}, {Str: "cool", Weight: 1}})
+	if !tr.Rename("colour", "colr") {
+		t.Fatal("Rename(colour, colr) got false")
+	}
+	if got := tr.Get("col"); !reflect.DeepEqual(got, []string{"colr", "cold", "color"}) {
+		t.Fatalf("Get(col) after Rename got %q", got)
+	}
+	if kw, _ := tr.Best("colr"); kw.Weight != 7 {
+		t.Fatalf("the renamed keyword has weight %d, want 7", kw.Weight)
+	}
+	if got := tr.GetAll("colou"); len(got) != 0 || tr.Len() != 4 {
+		t.Fatalf("the old spelling is still found: %v, Len %d", got, tr.Len())
+	}
+
+	// Renaming onto another keyword is rejected, an absent keyword is not renamed.
+	if tr.Rename("colr", "cold") || tr.Rename("colour", "colours") {
+		t.Fatal("Rename onto an existing keyword or of an absent keyword got true")
+	}
+	if got := tr.Export(); !reflect.DeepEqual(strs(got), []string{"colr", "cold", "color", "cool"}) {
+		t.Fatalf("a rejected Rename changed the keywords: %v", got)
+	}
+	if !tr.Rename("cold", "cold") {
+		t.Fatal("Rename onto itself got false")
+	}
+	if err := tr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tr := build(3, []Keyword{{Str: "apple", Weight: 5}, {Str: "apricot", Weight: 3}, {Str: "avocado", Weight: 4}})
+	for i := 1; i <= 6; i++ {
+		tr.Add("apricot", 1)
+		kw, _ := tr.Best("apricot")
+		if kw.Weight != 3+i {
+			t.Fatalf("weight after %d Adds got %d, want %d", i, kw.Weight, 3+i)
+		}
+	}
+	if got := tr.Get("a"); !reflect.DeepEqual(got, []string{"apricot", "apple", "avocado"}) {
+		t.Fatalf("Get(a) after the Adds got %q, apricot must rank first", got)
+	}
+	tr.Add("banana", 2) // a new keyword is put with weight delta
+	if kw, ok := tr.Best("b"); !ok || kw.Str != "banana" || kw.Weight != 2 {
+		t.Fatalf("Best(b) got %v %v", kw, ok)
+	}
+	if err := tr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutR(t *testing.T) {
+	tr := New(3)
+	if forked, inserted := tr.PutR("go", 1); forked || !inserted {
+		t.Fatalf("PutR(go) got forked %v, inserted %v", forked, inserted)
+	}
+	// "go" is a leaf under g, "golf" splits it at the divergence.
+	if forked, inserted := tr.PutR("golf", 2); !forked || !inserted {
+		t.Fatalf("PutR(golf) got forked %v, inserted %v", forked, inserted)
+	}
+	if forked, inserted := tr.PutR("golf", 3); forked || inserted {
+		t.Fatalf("PutR(golf) again got forked %v, inserted %v", forked, inserted)
+	}
+	if got := tr.Get("go"); !reflect.DeepEqual(got, []string{"golf", "go"}) {
+		t.Fatalf("Get(go) got %q", got)
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	tr := build(3, []Keyword{{Str: "a", Weight: 1}, {Str: "ab", Weight: 1}, {Str: "abcd", Weight: 1}})
+	for query, want := range map[string]string{"abc": "ab", "abcd": "abcd", "abcde": "abcd", "a": "a", "ax": "a", "": "", "b": ""} {
+		kw, ok := tr.MatchPrefix(query)
+		if ok != (want != "") || kw.Str != want {
+			t.Fatalf("MatchPrefix(%q) got %q %v, want %q", query, kw.Str, ok, want)
+		}
+	}
+}
+
+func TestPrecompute(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for name, mk := range map[string]func(int) *TireKWP{"New": New, "NewCOW": NewCOW} {
+		kws := genKeywords(r, 400, 6, "abcd")
+		tr := mk(3)
+		tr.PutAll(kws)
+		// The prefixes of each keyword share ancestry, the random ones are mostly absent.
+		prefixes := []string{"", "x", "abcdabcd"}
+		for _, kw := range kws[:100] {
+			rs := []rune(kw.Str)
+			for i := 0; i <= len(rs); i++ {
+				prefixes = append(prefixes, string(rs[:i]))
+			}
+		}
+		for _, kw := range genKeywords(r, 100, 8, "abcdx") {
+			prefixes = append(prefixes, kw.Str)
+		}
+
+		res := tr.Precompute(prefixes)
+		for _, p := range prefixes {
+			want := make([]Keyword, 0)
+			for _, kw := range tr.GetKWs(p) {
+				want = append(want, *kw)
+			}
+			if !reflect.DeepEqual(res[p], want) {
+				t.Fatalf("%s: Precompute()[%q] got %v, want %v", name, p, res[p], want)
+			}
+		}
+	}
+}
+
+func TestBreadcrumb(t *testing.T) {
+	tr := build(3, []Keyword{{Str: "go", Weight: 1}, {Str: "golf", Weight: 2}, {Str: "golfing", Weight: 3}, {Str: "gopher", Weight: 4}})
+	want := []Crumb{{"g", 4}, {"go", 4}, {"gol", 2}, {"golf", 2}, {"golfi", 1}}
+	if got := tr.Breadcrumb("golfix"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Breadcrumb(golfix) got %v, want %v", got, want)
+	}
+	if got := tr.Breadcrumb("x"); len(got) != 0 {
+		t.Fatalf("Breadcrumb(x) got %v", got)
+	}
+
+	r := rand.New(rand.NewSource(9))
+	tr = build(3, genKeywords(r, 500, 8, "abc"))
+	for _, kw := range genKeywords(r, 200, 10, "abcd") {
+		crumbs := tr.Breadcrumb(kw.Str)
+		rs := []rune(kw.Str)
+		for i, c := range crumbs {
+			if c.Prefix != string(rs[:i+1]) || c.Count <= 0 || c.Count != tr.CountPrefix(c.Prefix) {
+				t.Fatalf("Breadcrumb(%q)[%d] got %v, CountPrefix %d", kw.Str, i, c, tr.CountPrefix(c.Prefix))
+			}
+		}
+		if n := len(crumbs); n < len(rs) && tr.CountPrefix(string(rs[:n+1])) != 0 {
+			t.Fatalf("Breadcrumb(%q) stopped at %d runes", kw.Str, n)
+		}
+	}
+}
+
+func TestInsertionOrder(t *testing.T) {
+	r := rand.New(rand.NewSource(99))
+	var kws []Keyword
+	seen := make(map[string]bool)
+	for _, kw := range genKeywords(r, 600, 5, "abc") {
+		if !seen[kw.Str] {
+			seen[kw.Str] = true
+			kws = append(kws, Keyword{Str: kw.Str, Weight: kw.Weight % 3}) // many ties evicted on overflow
+		}
+	}
+	a := build(2, kws)
+	for i := 0; i < 3; i++ {
+		r.Shuffle(len(kws), func(i, j int) { kws[i], kws[j] = kws[j], kws[i] })
+		mustSame(t, a, build(2, kws), kws)
+	}
+}
+
+func TestNewWithScorer(t *testing.T) {
+	tr := NewWithScorer(4, func(kw *Keyword, query string) float64 {
+		return -float64(len(kw.Str)) // shorter is better
+	})
+	for _, kw := range []Keyword{{Str: "golang", Weight: 9}, {Str: "gopher", Weight: 5}, {Str: "gox", Weight: 2}, {Str: "go", Weight: 1}, {Str: "goo", Weight: 0}} {
+		tr.Put(kw.Str, kw.Weight)
+	}
+	// goo is out of the top 4 by weight, so it's not a candidate even though it's short.
+	if got := tr.Get("go"); !reflect.DeepEqual(got, []string{"go", "gox", "golang", "gopher"}) {
+		t.Fatalf("Get(go) got %q", got)
+	}
+	if kw, ok := tr.Best("go"); !ok || kw.Str != "go" {
+		t.Fatalf("Best(go) got %v %v", kw, ok)
+	}
+}
+
+func TestNewWithTransform(t *testing.T) {
+	tr := NewWithTransform(5, func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return '0'
+		}
+		return r
+	})
+	for _, kw := range []Keyword{{Str: "abc1", Weight: 1}, {Str: "abc22", Weight: 2}, {Str: "abd", Weight: 3}} {
+		tr.Put(kw.Str, kw.Weight)
+	}
+	// The keywords keep their Str, digits only share a path.
+	if got := tr.Get("abc9"); !reflect.DeepEqual(got, []string{"abc22", "abc1"}) {
+		t.Fatalf("Get(abc9) got %q", got)
+	}
+	if got := tr.Get("abc97"); !reflect.DeepEqual(got, []string{"abc22"}) {
+		t.Fatalf("Get(abc97) got %q", got)
+	}
+	if err := tr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	tr := build(3, []Keyword{{Str: "go", Weight: 1}, {Str: "golf", Weight: 2}, {Str: "gopher", Weight: 3}})
+	tr.Delete("golf")
+	tr.Delete("gopher")
+	// "go" is left at depth 2 under a chain, where a new trie puts it as the leaf under g.
+	before := tr.Count()
+	tr.Optimize()
+	if tr.Count() >= before {
+		t.Fatalf("Count after Optimize got %d, want < %d", tr.Count(), before)
+	}
+	mustSame(t, build(3, []Keyword{{Str: "go", Weight: 1}}), tr, []Keyword{{Str: "go"}, {Str: "golf"}})
+
+	r := rand.New(rand.NewSource(8))
+	for name, mk := range map[string]func(int) *TireKWP{"New": New, "NewCOW": NewCOW} {
+		kws := genKeywords(r, 2000, 8, "abc")
+		tr := mk(4)
+		tr.PutAll(kws)
+		for _, kw := range kws[:1500] {
+			tr.Delete(kw.Str)
+		}
+		old := build(4, tr.Export())
+		before := tr.Count()
+		tr.Optimize()
+		if !reflect.DeepEqual(tr.Export(), old.Export()) {
+			t.Fatalf("%s: Optimize changed the keywords", name)
+		}
+		if tr.Count() > before {
+			t.Fatalf("%s: Count after Optimize got %d, want <= %d", name, tr.Count(), before)
+		}
+		mustSame(t, old, tr, kws)
+	}
+}
+
+func TestBest(t *testing.T) {
+	r := rand.New(rand.NewSource(22))
+	kws := genKeywords(r, 300, 6, "abc")
+	tr := build(3, kws)
+	for _, kw := range kws {
+		rs := []rune(kw.Str)
+		for i := 0; i <= len(rs); i++ {
+			p := string(rs[:i])
+			if got, ok := tr.Best(p); !ok || !reflect.DeepEqual(got, *tr.GetKWs(p)[0]) {
+				t.Fatalf("Best(%q) got %v %v, want %v", p, got, ok, *tr.GetKWs(p)[0])
+			}
+		}
+	}
+	if kw, ok := tr.Best("x"); ok {
+		t.Fatalf("Best(x) got %v", kw)
+	}
+	if kw, ok := New(3).Best(""); ok {
+		t.Fatalf("Best of an empty trie got %v", kw)
+	}
+}
+
+func TestGetTopK(t *testing.T) {
+	tr := build(10, []Keyword{{Str: "go", Weight: 1}, {Str: "golang", Weight: 9}, {Str: "gopher", Weight: 5}, {Str: "golf", Weight: 5}, {Str: "gx", Weight: 7}})
+	kws := tr.GetKWs("go")
+	want := []Keyword{*kws[0], *kws[1], *kws[2]}
+	if got := tr.GetTopK("go", 3); !reflect.DeepEqual(got, want) {
+		t.Fatalf("GetTopK(go, 3) got %v, want %v", got, want)
+	}
+	if got := tr.GetTopK("go", 10); len(got) != len(kws) {
+		t.Fatalf("GetTopK(go, 10) got %v", got)
+	}
+	if got := tr.GetTopK("go", 0); got != nil {
+		t.Fatalf("GetTopK(go, 0) got %v", got)
+	}
+}
+
+func TestGetHighlighted(t *testing.T) {
+	tr := build(3, []Keyword{{Str: "héllo", Weight: 1}, {Str: "hélp", Weight: 2}, {Str: "abc", Weight: 3}})
+	got := tr.GetHighlighted("hé")
+	if len(got) != 2 || got[0].Str != "hélp" || got[1].Str != "héllo" {
+		t.Fatalf("GetHighlighted(hé) got %v", got)
+	}
+	for _, h := range got {
+		if h.Start != 0 || h.End != len([]rune("hé")) {
+			t.Fatalf("GetHighlighted(hé) got %q at [%d, %d)", h.Str, h.Start, h.End)
+		}
+	}
+	if got := tr.GetHighlighted("x"); len(got) != 0 {
+		t.Fatalf("GetHighlighted(x) got %v", got)
+	}
+}
+
+func TestGetMaxLen(t *testing.T) {
+	tr := build(5, []Keyword{{Str: "ab", Weight: 1}, {Str: "abcdef", Weight: 9}, {Str: "abc", Weight: 3}, {Str: "abé", Weight: 4}})
+	// abé is 3 runes but 4 bytes.
+	if got := tr.GetMaxLen("a", 3); !reflect.DeepEqual(got, []string{"abé", "abc", "ab"}) {
+		t.Fatalf("GetMaxLen(a, 3) got %q", got)
+	}
+	if got := tr.GetMaxLen("a", 1); got != nil {
+		t.Fatalf("GetMaxLen(a, 1) got %q", got)
+	}
+}
+
+func TestGetFiltered(t *testing.T) {
+	tr := build(10, []Keyword{{Str: "go:lang", Weight: 0}, {Str: "go:pher", Weight: 1}, {Str: "gx:pro", Weight: 2}, {Str: "go:at", Weight: 3}})
+	got := tr.GetFiltered("g", func(kw *Keyword) bool {
+		return strings.HasPrefix(kw.Str, "go:") // the category is before ':'
+	})
+	if want := []string{"go:at", "go:pher", "go:lang"}; !reflect.DeepEqual(strs(got), want) {
+		t.Fatalf("GetFiltered got %v, want %q", got, want)
+	}
+}
+
+func TestSuggestionCount(t *testing.T) {
+	r := rand.New(rand.NewSource(17))
+	kws := genKeywords(r, 1000, 5, "abc")
+	tr := build(4, kws)
+	for _, kw := range kws[:300] {
+		tr.Delete(kw.Str)
+	}
+	for _, kw := range genKeywords(r, 100, 3, "abcd") {
+		n := tr.SuggestionCount(kw.Str)
+		if n != len(tr.Get(kw.Str)) || n > 4 || n > tr.CountPrefix(kw.Str) {
+			t.Fatalf("SuggestionCount(%q) got %d, Get %d, CountPrefix %d", kw.Str, n, len(tr.Get(kw.Str)), tr.CountPrefix(kw.Str))
+		}
+	}
+}
+
+func TestGetAlpha(t *testing.T) {
+	tr := build(3, []Keyword{{Str: "gob", Weight: 9}, {Str: "goa", Weight: 1}, {Str: "gox", Weight: 5}, {Str: "gom", Weight: 7}, {Str: "java", Weight: 10}})
+	// The top 3 by weight are gob, gom and gox, goa is out though it's the first alphabetically.
+	if got := tr.GetAlpha("go"); !reflect.DeepEqual(got, []string{"gob", "gom", "gox"}) {
+		t.Fatalf("GetAlpha(go) got %q", got)
+	}
+	if got := tr.GetAlpha("z"); len(got) != 0 {
+		t.Fatalf("GetAlpha(z) got %q", got)
+	}
+}
+
+func TestExport(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	tr := build(2, genKeywords(r, 300, 4, "abc")) // with repeated keywords
+	kws := tr.Export()
+	if len(kws) != tr.Len() {
+		t.Fatalf("Export got %d keywords, want Len %d", len(kws), tr.Len())
+	}
+	for i := 1; i < len(kws); i++ {
+		if cmp(&kws[i-1], &kws[i]) >= 0 {
+			t.Fatalf("Export got %v before %v", kws[i-1], kws[i])
+		}
+	}
+}
+
+func TestGetWeighted(t *testing.T) {
+	r := rand.New(rand.NewSource(19))
+	tr := build(3, genKeywords(r, 200, 4, "abc"))
+	for _, p := range []string{"", "a", "ab", "cab", "x"} {
+		got := tr.GetWeighted(p)
+		kws := tr.GetKWs(p)
+		if len(got) != len(kws) {
+			t.Fatalf("GetWeighted(%q) got %v, Get %q", p, got, tr.Get(p))
+		}
+		for _, kw := range kws {
+			if w, ok := got[kw.Str]; !ok || w != kw.Weight {
+				t.Fatalf("GetWeighted(%q)[%q] got %d %v, want %d", p, kw.Str, w, ok, kw.Weight)
+			}
+		}
+	}
+}